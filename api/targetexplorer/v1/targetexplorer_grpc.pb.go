@@ -0,0 +1,418 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: api/targetexplorer/v1/targetexplorer.proto
+
+package targetexplorerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	TargetExplorer_ListTargets_FullMethodName    = "/targetexplorer.v1.TargetExplorer/ListTargets"
+	TargetExplorer_ListEvents_FullMethodName     = "/targetexplorer.v1.TargetExplorer/ListEvents"
+	TargetExplorer_ListChanges_FullMethodName    = "/targetexplorer.v1.TargetExplorer/ListChanges"
+	TargetExplorer_ListQuarantine_FullMethodName = "/targetexplorer.v1.TargetExplorer/ListQuarantine"
+	TargetExplorer_WatchChanges_FullMethodName   = "/targetexplorer.v1.TargetExplorer/WatchChanges"
+	TargetExplorer_Reconcile_FullMethodName      = "/targetexplorer.v1.TargetExplorer/Reconcile"
+	TargetExplorer_ExcludeTarget_FullMethodName  = "/targetexplorer.v1.TargetExplorer/ExcludeTarget"
+	TargetExplorer_ReloadConfig_FullMethodName   = "/targetexplorer.v1.TargetExplorer/ReloadConfig"
+)
+
+// TargetExplorerClient is the client API for TargetExplorer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TargetExplorerClient interface {
+	// ListTargets returns the published targets, ordered by job.
+	ListTargets(ctx context.Context, in *ListTargetsRequest, opts ...grpc.CallOption) (*ListTargetsResponse, error)
+	// ListEvents returns the events waiting for the next consume.
+	ListEvents(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error)
+	// ListChanges returns recent target changes, oldest first.
+	ListChanges(ctx context.Context, in *ListChangesRequest, opts ...grpc.CallOption) (*ListChangesResponse, error)
+	// ListQuarantine returns the quarantined jobs and why, ordered by job.
+	ListQuarantine(ctx context.Context, in *ListQuarantineRequest, opts ...grpc.CallOption) (*ListQuarantineResponse, error)
+	// WatchChanges streams target changes as they are published. A subscriber
+	// that falls behind is disconnected and should resync with ListTargets.
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (TargetExplorer_WatchChangesClient, error)
+	// Reconcile consumes pending events and reconciles right away.
+	Reconcile(ctx context.Context, in *ReconcileRequest, opts ...grpc.CallOption) (*ReconcileResponse, error)
+	// ExcludeTarget drops a managed job until the exclusion lapses; a zero
+	// duration lifts an existing exclusion.
+	ExcludeTarget(ctx context.Context, in *ExcludeTargetRequest, opts ...grpc.CallOption) (*ExcludeTargetResponse, error)
+	// ReloadConfig re-reads the agent config and applies it, answering once
+	// the targets were reconciled under it.
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+}
+
+type targetExplorerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTargetExplorerClient(cc grpc.ClientConnInterface) TargetExplorerClient {
+	return &targetExplorerClient{cc}
+}
+
+func (c *targetExplorerClient) ListTargets(ctx context.Context, in *ListTargetsRequest, opts ...grpc.CallOption) (*ListTargetsResponse, error) {
+	out := new(ListTargetsResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_ListTargets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetExplorerClient) ListEvents(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error) {
+	out := new(ListEventsResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_ListEvents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetExplorerClient) ListChanges(ctx context.Context, in *ListChangesRequest, opts ...grpc.CallOption) (*ListChangesResponse, error) {
+	out := new(ListChangesResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_ListChanges_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetExplorerClient) ListQuarantine(ctx context.Context, in *ListQuarantineRequest, opts ...grpc.CallOption) (*ListQuarantineResponse, error) {
+	out := new(ListQuarantineResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_ListQuarantine_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetExplorerClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (TargetExplorer_WatchChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TargetExplorer_ServiceDesc.Streams[0], TargetExplorer_WatchChanges_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &targetExplorerWatchChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TargetExplorer_WatchChangesClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type targetExplorerWatchChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *targetExplorerWatchChangesClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *targetExplorerClient) Reconcile(ctx context.Context, in *ReconcileRequest, opts ...grpc.CallOption) (*ReconcileResponse, error) {
+	out := new(ReconcileResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_Reconcile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetExplorerClient) ExcludeTarget(ctx context.Context, in *ExcludeTargetRequest, opts ...grpc.CallOption) (*ExcludeTargetResponse, error) {
+	out := new(ExcludeTargetResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_ExcludeTarget_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *targetExplorerClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, TargetExplorer_ReloadConfig_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TargetExplorerServer is the server API for TargetExplorer service.
+// All implementations must embed UnimplementedTargetExplorerServer
+// for forward compatibility
+type TargetExplorerServer interface {
+	// ListTargets returns the published targets, ordered by job.
+	ListTargets(context.Context, *ListTargetsRequest) (*ListTargetsResponse, error)
+	// ListEvents returns the events waiting for the next consume.
+	ListEvents(context.Context, *ListEventsRequest) (*ListEventsResponse, error)
+	// ListChanges returns recent target changes, oldest first.
+	ListChanges(context.Context, *ListChangesRequest) (*ListChangesResponse, error)
+	// ListQuarantine returns the quarantined jobs and why, ordered by job.
+	ListQuarantine(context.Context, *ListQuarantineRequest) (*ListQuarantineResponse, error)
+	// WatchChanges streams target changes as they are published. A subscriber
+	// that falls behind is disconnected and should resync with ListTargets.
+	WatchChanges(*WatchChangesRequest, TargetExplorer_WatchChangesServer) error
+	// Reconcile consumes pending events and reconciles right away.
+	Reconcile(context.Context, *ReconcileRequest) (*ReconcileResponse, error)
+	// ExcludeTarget drops a managed job until the exclusion lapses; a zero
+	// duration lifts an existing exclusion.
+	ExcludeTarget(context.Context, *ExcludeTargetRequest) (*ExcludeTargetResponse, error)
+	// ReloadConfig re-reads the agent config and applies it, answering once
+	// the targets were reconciled under it.
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	mustEmbedUnimplementedTargetExplorerServer()
+}
+
+// UnimplementedTargetExplorerServer must be embedded to have forward compatible implementations.
+type UnimplementedTargetExplorerServer struct {
+}
+
+func (UnimplementedTargetExplorerServer) ListTargets(context.Context, *ListTargetsRequest) (*ListTargetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTargets not implemented")
+}
+func (UnimplementedTargetExplorerServer) ListEvents(context.Context, *ListEventsRequest) (*ListEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEvents not implemented")
+}
+func (UnimplementedTargetExplorerServer) ListChanges(context.Context, *ListChangesRequest) (*ListChangesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChanges not implemented")
+}
+func (UnimplementedTargetExplorerServer) ListQuarantine(context.Context, *ListQuarantineRequest) (*ListQuarantineResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListQuarantine not implemented")
+}
+func (UnimplementedTargetExplorerServer) WatchChanges(*WatchChangesRequest, TargetExplorer_WatchChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
+func (UnimplementedTargetExplorerServer) Reconcile(context.Context, *ReconcileRequest) (*ReconcileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reconcile not implemented")
+}
+func (UnimplementedTargetExplorerServer) ExcludeTarget(context.Context, *ExcludeTargetRequest) (*ExcludeTargetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExcludeTarget not implemented")
+}
+func (UnimplementedTargetExplorerServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedTargetExplorerServer) mustEmbedUnimplementedTargetExplorerServer() {}
+
+// UnsafeTargetExplorerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TargetExplorerServer will
+// result in compilation errors.
+type UnsafeTargetExplorerServer interface {
+	mustEmbedUnimplementedTargetExplorerServer()
+}
+
+func RegisterTargetExplorerServer(s grpc.ServiceRegistrar, srv TargetExplorerServer) {
+	s.RegisterService(&TargetExplorer_ServiceDesc, srv)
+}
+
+func _TargetExplorer_ListTargets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTargetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).ListTargets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_ListTargets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).ListTargets(ctx, req.(*ListTargetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetExplorer_ListEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).ListEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_ListEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).ListEvents(ctx, req.(*ListEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetExplorer_ListChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).ListChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_ListChanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).ListChanges(ctx, req.(*ListChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetExplorer_ListQuarantine_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListQuarantineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).ListQuarantine(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_ListQuarantine_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).ListQuarantine(ctx, req.(*ListQuarantineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetExplorer_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TargetExplorerServer).WatchChanges(m, &targetExplorerWatchChangesServer{stream})
+}
+
+type TargetExplorer_WatchChangesServer interface {
+	Send(*Change) error
+	grpc.ServerStream
+}
+
+type targetExplorerWatchChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *targetExplorerWatchChangesServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TargetExplorer_Reconcile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReconcileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).Reconcile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_Reconcile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).Reconcile(ctx, req.(*ReconcileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetExplorer_ExcludeTarget_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExcludeTargetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).ExcludeTarget(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_ExcludeTarget_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).ExcludeTarget(ctx, req.(*ExcludeTargetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TargetExplorer_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TargetExplorerServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TargetExplorer_ReloadConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TargetExplorerServer).ReloadConfig(ctx, req.(*ReloadConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TargetExplorer_ServiceDesc is the grpc.ServiceDesc for TargetExplorer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TargetExplorer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "targetexplorer.v1.TargetExplorer",
+	HandlerType: (*TargetExplorerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListTargets",
+			Handler:    _TargetExplorer_ListTargets_Handler,
+		},
+		{
+			MethodName: "ListEvents",
+			Handler:    _TargetExplorer_ListEvents_Handler,
+		},
+		{
+			MethodName: "ListChanges",
+			Handler:    _TargetExplorer_ListChanges_Handler,
+		},
+		{
+			MethodName: "ListQuarantine",
+			Handler:    _TargetExplorer_ListQuarantine_Handler,
+		},
+		{
+			MethodName: "Reconcile",
+			Handler:    _TargetExplorer_Reconcile_Handler,
+		},
+		{
+			MethodName: "ExcludeTarget",
+			Handler:    _TargetExplorer_ExcludeTarget_Handler,
+		},
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _TargetExplorer_ReloadConfig_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _TargetExplorer_WatchChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/targetexplorer/v1/targetexplorer.proto",
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github/rolandvarga/target-explorer/pkg/publisher"
+)
+
+const usage = `usage: target-explorer [command] [flags]
+
+commands:
+  run       watch containers and keep the Prometheus config up to date (default)
+  once      scan containers, publish the targets once and exit
+  validate  check the agent config and the existing Prometheus config
+  list      print the targets of the running containers
+  migrate   adopt the jobs of a hand-written Prometheus config
+  bundle    fetch a debug bundle from a running agent
+`
+
+func main() {
+	logger := publisher.NewLogger()
+
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		publisher.RunCommand(logger, args)
+	case "once":
+		publisher.OnceCommand(logger, args)
+	case "validate":
+		logger.SetOutput(os.Stderr)
+		publisher.ValidateCommand(logger, args)
+	case "list":
+		logger.SetOutput(os.Stderr)
+		publisher.ListCommand(logger, args)
+	case "migrate":
+		logger.SetOutput(os.Stderr)
+		publisher.MigrateCommand(logger, args)
+	case "bundle":
+		logger.SetOutput(os.Stderr)
+		publisher.BundleCommand(logger, args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,524 @@
+// Package dockerfake is an in-memory Docker engine satisfying producer.Docker, so the
+// discovery pipeline can be driven through container lifecycles without a daemon.
+package dockerfake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	// APIVersion is what Ping reports
+	APIVersion = "1.43"
+
+	stateRunning = "running"
+	statePaused  = "paused"
+	stateExited  = "exited"
+)
+
+// the methods Fail can make return an error
+const (
+	ContainerList    = "ContainerList"
+	ContainerInspect = "ContainerInspect"
+	Events           = "Events"
+	Ping             = "Ping"
+)
+
+var (
+	listFilters  = map[string]bool{"id": true, "name": true, "label": true, "status": true}
+	eventFilters = map[string]bool{"type": true, "event": true, "label": true, "container": true}
+)
+
+// Container is a container to Start; Ports maps container ports to their host bindings
+// the way `docker run -p` publishes them
+type Container struct {
+	ID       string
+	Name     string
+	Image    string
+	Labels   map[string]string
+	Ports    nat.PortMap
+	Networks []string
+}
+
+type record struct {
+	spec     Container
+	created  time.Time
+	started  time.Time
+	state    string
+	health   string
+	restarts int
+}
+
+// Engine is the fake daemon; the zero value isn't usable, build one with New
+type Engine struct {
+	mu            sync.Mutex
+	containers    map[string]*record
+	subscriptions map[*subscription]bool
+	failures      map[string]error
+}
+
+// New is an engine without containers
+func New() *Engine {
+	return &Engine{
+		containers:    make(map[string]*record),
+		subscriptions: make(map[*subscription]bool),
+		failures:      make(map[string]error),
+	}
+}
+
+// Fail makes method (ContainerList, ContainerInspect, Events or Ping) return err until
+// called again with a nil err. Failing Events also ends the open event streams with err
+func (e *Engine) Fail(method string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err == nil {
+		delete(e.failures, method)
+		return
+	}
+	e.failures[method] = err
+	if method == Events {
+		for s := range e.subscriptions {
+			s.fail(err)
+			delete(e.subscriptions, s)
+		}
+	}
+}
+
+// Start creates c, or starts it again if its ID is known, and emits the events docker would
+func (e *Engine) Start(c Container) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c = copyContainer(c)
+	now := time.Now()
+	r, ok := e.containers[c.ID]
+	if !ok {
+		r = &record{created: now}
+		e.containers[c.ID] = r
+		r.spec = c
+		e.emit(r, "create", now)
+	} else {
+		r.spec = c
+		r.restarts++
+	}
+	r.state = stateRunning
+	r.started = now
+	r.health = ""
+	e.emit(r, "start", now)
+}
+
+// Stop stops the container
+func (e *Engine) Stop(id string) error {
+	return e.transition(id, stateExited, "die", "stop")
+}
+
+// Pause pauses the container
+func (e *Engine) Pause(id string) error {
+	return e.transition(id, statePaused, "pause")
+}
+
+// Unpause resumes a paused container
+func (e *Engine) Unpause(id string) error {
+	return e.transition(id, stateRunning, "unpause")
+}
+
+// SetHealth reports the container as healthy or unhealthy, as its healthcheck would
+func (e *Engine) SetHealth(id, status string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.containers[id]
+	if !ok {
+		return notFound(id)
+	}
+	r.health = status
+	e.emit(r, "health_status: "+status, time.Now())
+	return nil
+}
+
+// Remove deletes the container, stopping it first if it still runs
+func (e *Engine) Remove(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.containers[id]
+	if !ok {
+		return notFound(id)
+	}
+	now := time.Now()
+	if r.state != stateExited {
+		r.state = stateExited
+		e.emit(r, "die", now)
+		e.emit(r, "stop", now)
+	}
+	delete(e.containers, id)
+	e.emit(r, "destroy", now)
+	return nil
+}
+
+// Emit sends a container event without changing the container, e.g. an oom or a
+// restart docker would emit on its own
+func (e *Engine) Emit(id, action string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.containers[id]
+	if !ok {
+		return notFound(id)
+	}
+	e.emit(r, action, time.Now())
+	return nil
+}
+
+func (e *Engine) transition(id, state string, actions ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r, ok := e.containers[id]
+	if !ok {
+		return notFound(id)
+	}
+	r.state = state
+	now := time.Now()
+	for _, action := range actions {
+		e.emit(r, action, now)
+	}
+	return nil
+}
+
+func (e *Engine) emit(r *record, action string, at time.Time) {
+	msg := events.Message{
+		Status: action,
+		ID:     r.spec.ID,
+		From:   r.spec.Image,
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         r.spec.ID,
+			Attributes: r.attributes(),
+		},
+		Scope:    "local",
+		Time:     at.Unix(),
+		TimeNano: at.UnixNano(),
+	}
+	for s := range e.subscriptions {
+		if matchEvent(s.filter, msg) {
+			s.send(msg)
+		}
+	}
+}
+
+// ContainerList lists the running containers, or all of them with options.All, narrowed
+// by the id, name, label and status filters
+func (e *Engine) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.failures[ContainerList]; err != nil {
+		return nil, err
+	}
+	if err := options.Filters.Validate(listFilters); err != nil {
+		return nil, err
+	}
+
+	containers := []types.Container{}
+	for _, r := range e.containers {
+		if !options.All && r.state == stateExited {
+			continue
+		}
+		c := r.summary()
+		if !matchContainer(options.Filters, c) {
+			continue
+		}
+		containers = append(containers, c)
+	}
+	// docker lists the newest first
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].Created != containers[j].Created {
+			return containers[i].Created > containers[j].Created
+		}
+		return containers[i].ID < containers[j].ID
+	})
+	return containers, nil
+}
+
+// ContainerInspect describes the container, failing like docker for an unknown one
+func (e *Engine) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.failures[ContainerInspect]; err != nil {
+		return types.ContainerJSON{}, err
+	}
+	r, ok := e.containers[id]
+	if !ok {
+		return types.ContainerJSON{}, notFound(id)
+	}
+	return r.inspect(), nil
+}
+
+// Events streams the container events matching the type, event, label and container
+// filters until ctx is done
+func (e *Engine) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message)
+	errs := make(chan error, 1)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.failures[Events]; err != nil {
+		errs <- err
+		return msgs, errs
+	}
+	if err := options.Filters.Validate(eventFilters); err != nil {
+		errs <- err
+		return msgs, errs
+	}
+
+	s := &subscription{filter: options.Filters.Clone(), wake: make(chan struct{}, 1)}
+	e.subscriptions[s] = true
+	go func() {
+		err := s.pump(ctx, msgs)
+		e.mu.Lock()
+		delete(e.subscriptions, s)
+		e.mu.Unlock()
+		errs <- err
+	}()
+	return msgs, errs
+}
+
+// Ping answers like a daemon speaking APIVersion
+func (e *Engine) Ping(ctx context.Context) (types.Ping, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.failures[Ping]; err != nil {
+		return types.Ping{}, err
+	}
+	return types.Ping{APIVersion: APIVersion, OSType: "linux"}, nil
+}
+
+// subscription queues the events of one stream, so emitting never waits on its reader
+type subscription struct {
+	filter filters.Args
+
+	mu    sync.Mutex
+	queue []events.Message
+	err   error
+	wake  chan struct{}
+}
+
+func (s *subscription) send(msg events.Message) {
+	s.mu.Lock()
+	s.queue = append(s.queue, msg)
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *subscription) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *subscription) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) pump(ctx context.Context, msgs chan<- events.Message) error {
+	for {
+		s.mu.Lock()
+		queue, err := s.queue, s.err
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, msg := range queue {
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *record) attributes() map[string]string {
+	attrs := make(map[string]string, len(r.spec.Labels)+2)
+	for k, v := range r.spec.Labels {
+		attrs[k] = v
+	}
+	attrs["name"] = r.spec.Name
+	attrs["image"] = r.spec.Image
+	return attrs
+}
+
+func (r *record) status() string {
+	switch r.state {
+	case statePaused:
+		return "Up (Paused)"
+	case stateExited:
+		return "Exited (0)"
+	}
+	if r.health != "" {
+		return fmt.Sprintf("Up (%s)", r.health)
+	}
+	return "Up"
+}
+
+func (r *record) summary() types.Container {
+	c := types.Container{
+		ID:      r.spec.ID,
+		Names:   []string{"/" + r.spec.Name},
+		Image:   r.spec.Image,
+		Created: r.created.Unix(),
+		Labels:  r.spec.Labels,
+		State:   r.state,
+		Status:  r.status(),
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: r.networks(),
+		},
+	}
+	if r.state == stateExited {
+		return c
+	}
+	for port, bindings := range r.spec.Ports {
+		for _, b := range bindings {
+			public, _ := strconv.ParseUint(b.HostPort, 10, 16)
+			c.Ports = append(c.Ports, types.Port{
+				IP:          b.HostIP,
+				PrivatePort: uint16(port.Int()),
+				PublicPort:  uint16(public),
+				Type:        port.Proto(),
+			})
+		}
+	}
+	return c
+}
+
+func (r *record) inspect() types.ContainerJSON {
+	state := &types.ContainerState{
+		Status:    r.state,
+		Running:   r.state != stateExited,
+		Paused:    r.state == statePaused,
+		StartedAt: r.started.Format(time.RFC3339Nano),
+	}
+	if r.health != "" {
+		state.Health = &types.Health{Status: r.health}
+	}
+
+	ports := nat.PortMap{}
+	if r.state != stateExited {
+		ports = r.spec.Ports
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:           r.spec.ID,
+			Name:         "/" + r.spec.Name,
+			Created:      r.created.Format(time.RFC3339Nano),
+			Image:        r.spec.Image,
+			State:        state,
+			RestartCount: r.restarts,
+			HostConfig:   &container.HostConfig{},
+		},
+		Config: &container.Config{
+			Hostname: r.hostname(),
+			Image:    r.spec.Image,
+			Labels:   r.spec.Labels,
+		},
+		NetworkSettings: &types.NetworkSettings{
+			NetworkSettingsBase: types.NetworkSettingsBase{Ports: ports},
+			Networks:            r.networks(),
+		},
+	}
+}
+
+func (r *record) hostname() string {
+	if len(r.spec.ID) > 12 {
+		return r.spec.ID[:12]
+	}
+	return r.spec.ID
+}
+
+func (r *record) networks() map[string]*network.EndpointSettings {
+	networks := make(map[string]*network.EndpointSettings, len(r.spec.Networks))
+	for _, name := range r.spec.Networks {
+		networks[name] = &network.EndpointSettings{}
+	}
+	return networks
+}
+
+func matchContainer(f filters.Args, c types.Container) bool {
+	if f.Contains("id") && !f.FuzzyMatch("id", c.ID) {
+		return false
+	}
+	if f.Contains("name") && !f.Match("name", strings.TrimPrefix(c.Names[0], "/")) {
+		return false
+	}
+	if f.Contains("status") && !f.ExactMatch("status", c.State) {
+		return false
+	}
+	return f.MatchKVList("label", c.Labels)
+}
+
+func matchEvent(f filters.Args, msg events.Message) bool {
+	if f.Contains("type") && !f.ExactMatch("type", string(msg.Type)) {
+		return false
+	}
+	// health_status events are filtered without their status, like docker does
+	action, _, _ := strings.Cut(msg.Action, ":")
+	if f.Contains("event") && !f.ExactMatch("event", msg.Action) && !f.ExactMatch("event", action) {
+		return false
+	}
+	if f.Contains("container") && !f.ExactMatch("container", msg.Actor.ID) && !f.ExactMatch("container", msg.Actor.Attributes["name"]) {
+		return false
+	}
+	return f.MatchKVList("label", msg.Actor.Attributes)
+}
+
+func copyContainer(c Container) Container {
+	labels := make(map[string]string, len(c.Labels))
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	c.Labels = labels
+
+	ports := make(nat.PortMap, len(c.Ports))
+	for port, bindings := range c.Ports {
+		ports[port] = append([]nat.PortBinding(nil), bindings...)
+	}
+	c.Ports = ports
+
+	c.Networks = append([]string(nil), c.Networks...)
+	return c
+}
+
+func notFound(id string) error {
+	return errdefs.NotFound(fmt.Errorf("No such container: %s", id))
+}
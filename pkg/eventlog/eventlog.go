@@ -0,0 +1,130 @@
+// Package eventlog holds the container events the producers observe until the consumer
+// turns them into targets.
+package eventlog
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type is the kind of container event, after the docker action it came from
+type Type int
+
+const (
+	// HealthStatusAction is the docker action of health events, reported as "health_status: <status>"
+	HealthStatusAction = "health_status"
+	healthStatusPrefix = HealthStatusAction + ": "
+)
+
+const (
+	Start Type = iota + 1
+	Running
+	Stop
+	Die
+	Pause
+	Unpause
+	Restart
+	OOM
+	Healthy
+	Unhealthy
+)
+
+// eventTable maps docker actions to event types; health_status actions are keyed by their status
+var eventTable = map[string]Type{
+	"start":     Start,
+	"running":   Running,
+	"stop":      Stop,
+	"die":       Die,
+	"pause":     Pause,
+	"unpause":   Unpause,
+	"restart":   Restart,
+	"oom":       OOM,
+	"healthy":   Healthy,
+	"unhealthy": Unhealthy,
+}
+
+func (t Type) String() string {
+	for name, et := range eventTable {
+		if et == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// ParseAction maps a docker event action to its Type, zero for actions without one
+func ParseAction(action string) Type {
+	return eventTable[strings.TrimPrefix(action, healthStatusPrefix)]
+}
+
+// Event is a container changing state; Up is whether the event policy adds its target or removes it
+type Event struct {
+	Action      Type
+	Up          bool
+	ContainerID string
+	Name        string
+	RecordedAt  time.Time
+}
+
+// Log buffers events between consume cycles; it is safe for concurrent use
+type Log struct {
+	mu       sync.Mutex
+	events   []Event
+	notifyCh chan struct{}
+}
+
+// New returns an empty Log
+func New() *Log {
+	return &Log{
+		mu:       sync.Mutex{},
+		events:   make([]Event, 0),
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+// Push appends an event and wakes up whoever waits on Notify
+func (el *Log) Push(e Event) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.events = append(el.events, e)
+
+	select {
+	case el.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Len is the number of events waiting for the next consume
+func (el *Log) Len() int {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	return len(el.events)
+}
+
+// Peek returns the events waiting for the next consume without taking them
+func (el *Log) Peek() []Event {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	out := make([]Event, len(el.events))
+	copy(out, el.events)
+	return out
+}
+
+// Notify receives once after one or more pushes
+func (el *Log) Notify() <-chan struct{} {
+	return el.notifyCh
+}
+
+// Flush takes every waiting event, leaving the log empty
+func (el *Log) Flush() []Event {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	out := make([]Event, len(el.events))
+	copy(out, el.events)
+	el.events = nil
+
+	return out
+}
@@ -0,0 +1,116 @@
+package producer
+
+import (
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+// ErrConfigInvalid is wrapped by every validation error of the config sections owned here
+var ErrConfigInvalid = fmt.Errorf("config validating")
+
+// CredentialsConfig is where Prometheus finds the Docker secrets that scrape credential labels name
+type CredentialsConfig struct {
+	SecretsDir string `yaml:"secrets_dir"`
+}
+
+// EventsConfig sets what pause, unpause, restart, oom, healthy and unhealthy events do
+// to a container's target: add, remove or ignore
+type EventsConfig map[string]Behavior
+
+// CompatConfig keeps the prometheus.io/* annotations other tools use working
+type CompatConfig struct {
+	PrometheusIO bool `yaml:"prometheus_io"`
+}
+
+// FiltersConfig selects which containers become targets
+type FiltersConfig struct {
+	Include []FilterRuleConfig `yaml:"include"`
+	Exclude []FilterRuleConfig `yaml:"exclude"`
+}
+
+// FilterRuleConfig matches when every field set matches; name, image and network are regular
+// expressions, label is either a label name or name=value
+type FilterRuleConfig struct {
+	Name    string `yaml:"name"`
+	Image   string `yaml:"image"`
+	Label   string `yaml:"label"`
+	Network string `yaml:"network"`
+}
+
+// IdentityConfig orders the sources a container's job name is taken from
+type IdentityConfig struct {
+	Template   string              `yaml:"template"`
+	Precedence []string            `yaml:"precedence"`
+	Tenants    map[string][]string `yaml:"tenants"`
+}
+
+// Config is how the producers themselves run
+type Config struct {
+	ScanInterval time.Duration `yaml:"scan_interval"`
+}
+
+// Validate checks every rule compiles
+func (fc FiltersConfig) Validate() error {
+	rules := append(append([]FilterRuleConfig{}, fc.Include...), fc.Exclude...)
+	for i, rule := range rules {
+		if rule == (FilterRuleConfig{}) {
+			return fmt.Errorf("%v: filter rule %d has no conditions", ErrConfigInvalid, i)
+		}
+		for _, pattern := range []string{rule.Name, rule.Image, rule.Network} {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("%v: filter rule %d: %s", ErrConfigInvalid, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks only configurable events with known behaviors are set
+func (ec EventsConfig) Validate() error {
+	for name, behavior := range ec {
+		if _, ok := defaultEventBehaviors[name]; !ok {
+			return fmt.Errorf("%v: unknown event %q", ErrConfigInvalid, name)
+		}
+		switch behavior {
+		case BehaviorAdd, BehaviorRemove, BehaviorIgnore:
+		default:
+			return fmt.Errorf("%v: event %s has unknown behavior %q", ErrConfigInvalid, name, behavior)
+		}
+	}
+	return nil
+}
+
+// Validate checks the precedence names known sources
+func (ic IdentityConfig) Validate() error {
+	if len(ic.Precedence) == 0 {
+		return fmt.Errorf("%v: identity precedence must not be empty", ErrConfigInvalid)
+	}
+	lists := map[string][]string{"": ic.Precedence}
+	for tenant, precedence := range ic.Tenants {
+		if len(precedence) == 0 {
+			return fmt.Errorf("%v: identity precedence of tenant %q must not be empty", ErrConfigInvalid, tenant)
+		}
+		lists[tenant] = precedence
+	}
+
+	for _, precedence := range lists {
+		for _, source := range precedence {
+			if source == templateSource && ic.Template == "" {
+				return fmt.Errorf("%v: identity source %q needs identity.template", ErrConfigInvalid, source)
+			}
+			if _, ok := identitySources[source]; !ok && source != templateSource {
+				return fmt.Errorf("%v: unknown identity source %q", ErrConfigInvalid, source)
+			}
+		}
+	}
+
+	if ic.Template != "" {
+		_, err := template.New("job").Parse(ic.Template)
+		if err != nil {
+			return fmt.Errorf("%v: identity template: %s", ErrConfigInvalid, err)
+		}
+	}
+	return nil
+}
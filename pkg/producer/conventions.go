@@ -0,0 +1,204 @@
+package producer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	scrapeTargetLabel = "scrape_target"
+	scrapePortLabel   = "scrape_port"
+	scrapePathLabel   = "scrape_path"
+	GroupLabel        = "scrape_group"
+	HostLabel         = "scrape_host"
+
+	scrapeTLSLabel                   = "scrape_tls"
+	scrapeTLSCAFileLabel             = "scrape_tls_ca_file"
+	scrapeTLSInsecureSkipVerifyLabel = "scrape_tls_insecure_skip_verify"
+
+	scrapeUsernameLabel       = "scrape_basic_auth_username"
+	scrapePasswordLabel       = "scrape_basic_auth_password"
+	scrapePasswordFileLabel   = "scrape_basic_auth_password_file"
+	scrapePasswordSecretLabel = "scrape_basic_auth_password_secret"
+	scrapeTokenLabel          = "scrape_bearer_token"
+	scrapeTokenFileLabel      = "scrape_bearer_token_file"
+	scrapeTokenSecretLabel    = "scrape_bearer_token_secret"
+
+	promIOScrapeLabel = "prometheus.io/scrape"
+	promIOPortLabel   = "prometheus.io/port"
+	promIOPathLabel   = "prometheus.io/path"
+	promIOSchemeLabel = "prometheus.io/scheme"
+
+	MetricsPathLabel = "__metrics_path__"
+
+	MetricsPort = "2112/tcp"
+)
+
+// Conventions names the container labels that opt a container in and point at its
+// metrics endpoint; the agent's own labels take precedence over the prometheus.io/* ones
+type Conventions struct {
+	scrape     []string
+	port       []string
+	path       []string
+	scheme     []string
+	secretsDir string
+}
+
+// NewConventions reads the label names to honor from the compat settings
+func NewConventions(compat CompatConfig, credentials CredentialsConfig) Conventions {
+	sc := Conventions{
+		scrape:     []string{scrapeTargetLabel},
+		port:       []string{scrapePortLabel},
+		path:       []string{scrapePathLabel},
+		secretsDir: credentials.SecretsDir,
+	}
+	if compat.PrometheusIO {
+		sc.scrape = append(sc.scrape, promIOScrapeLabel)
+		sc.port = append(sc.port, promIOPortLabel)
+		sc.path = append(sc.path, promIOPathLabel)
+		sc.scheme = append(sc.scheme, promIOSchemeLabel)
+	}
+	return sc
+}
+
+func lookupLabel(labels map[string]string, names []string) (string, bool) {
+	for _, name := range names {
+		if value, ok := labels[name]; ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// optedIn reports whether the container carries a scrape label, and if so its value
+func (sc Conventions) optedIn(labels map[string]string) (bool, bool) {
+	value, ok := lookupLabel(labels, sc.scrape)
+	if !ok {
+		return false, false
+	}
+	isTarget, err := strconv.ParseBool(value)
+	return err == nil && isTarget, true
+}
+
+// MetricsPort is the container port to publish as the target, 2112/tcp unless labeled otherwise
+func (sc Conventions) MetricsPort(labels map[string]string) (string, error) {
+	value, ok := lookupLabel(labels, sc.port)
+	if !ok {
+		return MetricsPort, nil
+	}
+
+	port, proto, _ := strings.Cut(value, "/")
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return "", fmt.Errorf("invalid scrape port %q", value)
+	}
+	if proto == "" {
+		proto = "tcp"
+	}
+	return port + "/" + proto, nil
+}
+
+// MetricsPath is the labeled metrics path, empty for Prometheus' default
+func (sc Conventions) MetricsPath(labels map[string]string) (string, error) {
+	path, ok := lookupLabel(labels, sc.path)
+	if !ok {
+		return "", nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("invalid scrape path %q, it must start with /", path)
+	}
+	return path, nil
+}
+
+// ScrapeTLS turns on https for a job scraped from its own scrape_config; the CA file is a path as
+// Prometheus sees it
+func (sc Conventions) ScrapeTLS(labels map[string]string) (ScrapeTLS, error) {
+	var tls ScrapeTLS
+	if value, ok := labels[scrapeTLSLabel]; ok {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return tls, fmt.Errorf("invalid %s %q", scrapeTLSLabel, value)
+		}
+		tls.Enabled = enabled
+	} else if scheme, ok := lookupLabel(labels, sc.scheme); ok {
+		tls.Enabled = scheme == "https"
+	}
+	if !tls.Enabled {
+		return tls, nil
+	}
+
+	tls.CAFile = labels[scrapeTLSCAFileLabel]
+	if value, ok := labels[scrapeTLSInsecureSkipVerifyLabel]; ok {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return tls, fmt.Errorf("invalid %s %q", scrapeTLSInsecureSkipVerifyLabel, value)
+		}
+		tls.InsecureSkipVerify = skip
+	}
+	return tls, nil
+}
+
+// ScrapeAuth reads the credentials a job is scraped with; labels only ever point at a file or a
+// Docker secret, which Prometheus reads itself, so no secret ends up in a label or prometheus.yaml
+func (sc Conventions) ScrapeAuth(labels map[string]string) (ScrapeAuth, error) {
+	var auth ScrapeAuth
+	for _, name := range []string{scrapePasswordLabel, scrapeTokenLabel} {
+		if _, ok := labels[name]; ok {
+			return auth, fmt.Errorf("label %s would expose the secret, use %s_file or %s_secret instead", name, name, name)
+		}
+	}
+
+	var err error
+	auth.Username = labels[scrapeUsernameLabel]
+	auth.PasswordFile, err = sc.credentialsFile(labels, scrapePasswordFileLabel, scrapePasswordSecretLabel)
+	if err != nil {
+		return auth, err
+	}
+	auth.TokenFile, err = sc.credentialsFile(labels, scrapeTokenFileLabel, scrapeTokenSecretLabel)
+	if err != nil {
+		return auth, err
+	}
+
+	if (auth.Username != "") != (auth.PasswordFile != "") {
+		return auth, fmt.Errorf("basic auth needs both %s and a password file or secret", scrapeUsernameLabel)
+	}
+	if auth.Username != "" && auth.TokenFile != "" {
+		return auth, fmt.Errorf("basic auth and a bearer token are mutually exclusive")
+	}
+	return auth, nil
+}
+
+func (sc Conventions) credentialsFile(labels map[string]string, fileLabel, secretLabel string) (string, error) {
+	path, hasFile := labels[fileLabel]
+	secret, hasSecret := labels[secretLabel]
+	switch {
+	case hasFile && hasSecret:
+		return "", fmt.Errorf("%s and %s are mutually exclusive", fileLabel, secretLabel)
+	case hasFile:
+		if !filepath.IsAbs(path) {
+			return "", fmt.Errorf("invalid %s %q, it must be an absolute path", fileLabel, path)
+		}
+		return path, nil
+	case hasSecret:
+		if secret == "" || strings.ContainsAny(secret, `/\`) || secret == "." || secret == ".." {
+			return "", fmt.Errorf("invalid %s %q", secretLabel, secret)
+		}
+		return filepath.Join(sc.secretsDir, secret), nil
+	}
+	return "", nil
+}
+
+// ScrapeTLS is how a target is scraped over TLS
+type ScrapeTLS struct {
+	Enabled            bool
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// ScrapeAuth names the secret files a target is scraped with
+type ScrapeAuth struct {
+	Username     string
+	PasswordFile string
+	TokenFile    string
+}
@@ -0,0 +1,98 @@
+package producer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+// ErrSuspended is returned for containers the policy keeps out while their state lasts
+var ErrSuspended = fmt.Errorf("container suspended by event policy")
+
+// Behavior is what an event does to a container's target
+type Behavior string
+
+const (
+	BehaviorAdd    Behavior = "add"
+	BehaviorRemove Behavior = "remove"
+	BehaviorIgnore Behavior = "ignore"
+)
+
+// defaultEventBehaviors are the events whose behavior can be configured; start, running,
+// stop and die always add or remove the target
+var defaultEventBehaviors = map[string]Behavior{
+	"pause":     BehaviorRemove,
+	"unpause":   BehaviorAdd,
+	"restart":   BehaviorAdd,
+	"oom":       BehaviorIgnore,
+	"healthy":   BehaviorAdd,
+	"unhealthy": BehaviorRemove,
+}
+
+// Policy decides what each kind of event does to a container's target
+type Policy struct {
+	behaviors map[eventlog.Type]Behavior
+}
+
+// NewPolicy applies cfg over the default behaviors
+func NewPolicy(cfg EventsConfig) Policy {
+	p := Policy{behaviors: map[eventlog.Type]Behavior{
+		eventlog.Start:   BehaviorAdd,
+		eventlog.Running: BehaviorAdd,
+		eventlog.Stop:    BehaviorRemove,
+		eventlog.Die:     BehaviorRemove,
+	}}
+	for name, behavior := range defaultEventBehaviors {
+		if b, ok := cfg[name]; ok {
+			behavior = b
+		}
+		p.behaviors[eventlog.ParseAction(name)] = behavior
+	}
+	return p
+}
+
+func (p Policy) behavior(t eventlog.Type) Behavior {
+	b, ok := p.behaviors[t]
+	if !ok {
+		return BehaviorIgnore
+	}
+	return b
+}
+
+// actions are the docker event actions worth subscribing to, those that aren't ignored;
+// running only ever comes from scans
+func (p Policy) actions() []string {
+	seen := make(map[string]bool)
+	for t, b := range p.behaviors {
+		if b == BehaviorIgnore || t == eventlog.Running {
+			continue
+		}
+		action := t.String()
+		if t == eventlog.Healthy || t == eventlog.Unhealthy {
+			action = eventlog.HealthStatusAction
+		}
+		seen[action] = true
+	}
+
+	actions := make([]string, 0, len(seen))
+	for action := range seen {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// Suspended reports whether a running container is in a state the policy keeps out of the
+// targets, so scans and reconciles agree with the pause and unhealthy events they may have missed
+func (p Policy) Suspended(state *types.ContainerState) bool {
+	if state == nil {
+		return false
+	}
+	if state.Paused && p.behavior(eventlog.Pause) == BehaviorRemove {
+		return true
+	}
+	return state.Health != nil && state.Health.Status == types.Unhealthy && p.behavior(eventlog.Unhealthy) == BehaviorRemove
+}
@@ -0,0 +1,167 @@
+package producer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ErrFiltered is returned for containers the filters don't select
+var ErrFiltered = fmt.Errorf("container not selected by filters")
+
+type filterRule struct {
+	name    *regexp.Regexp
+	image   *regexp.Regexp
+	label   string
+	value   *string
+	network *regexp.Regexp
+}
+
+// Filter decides which containers become targets: those labeled scrape_target=true
+// or matching an include rule, minus those matching an exclude rule
+type Filter struct {
+	conventions Conventions
+	include     []filterRule
+	exclude     []filterRule
+}
+
+// Subject is what the filters know about a container
+type Subject struct {
+	name   string
+	image  string
+	labels map[string]string
+	// networks is nil when not known, e.g. for events, and network conditions are then deferred to the inspect
+	networks []string
+}
+
+// NewFilter compiles cfg; it must have been validated
+func NewFilter(cfg FiltersConfig, conventions Conventions) Filter {
+	f := Filter{conventions: conventions}
+	for _, rc := range cfg.Include {
+		f.include = append(f.include, newFilterRule(rc))
+	}
+	for _, rc := range cfg.Exclude {
+		f.exclude = append(f.exclude, newFilterRule(rc))
+	}
+	return f
+}
+
+func newFilterRule(rc FilterRuleConfig) filterRule {
+	var r filterRule
+	if rc.Name != "" {
+		r.name = regexp.MustCompile(rc.Name)
+	}
+	if rc.Image != "" {
+		r.image = regexp.MustCompile(rc.Image)
+	}
+	if rc.Label != "" {
+		name, value, ok := strings.Cut(rc.Label, "=")
+		r.label = name
+		if ok {
+			r.value = &value
+		}
+	}
+	if rc.Network != "" {
+		r.network = regexp.MustCompile(rc.Network)
+	}
+	return r
+}
+
+func (r filterRule) matches(s Subject) bool {
+	if r.name != nil && !r.name.MatchString(s.name) {
+		return false
+	}
+	if r.image != nil && !r.image.MatchString(s.image) {
+		return false
+	}
+	if r.label != "" {
+		value, ok := s.labels[r.label]
+		if !ok || (r.value != nil && value != *r.value) {
+			return false
+		}
+	}
+	if r.network != nil && s.networks != nil {
+		matched := false
+		for _, network := range s.networks {
+			matched = matched || r.network.MatchString(network)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Selects reports whether s becomes a target
+func (f Filter) Selects(s Subject) bool {
+	s.name = strings.TrimPrefix(s.name, "/")
+
+	for _, r := range f.exclude {
+		// an exclusion on networks can't be decided without knowing them
+		if r.network != nil && s.networks == nil {
+			continue
+		}
+		if r.matches(s) {
+			return false
+		}
+	}
+
+	if isTarget, labeled := f.conventions.optedIn(s.labels); labeled {
+		return isTarget
+	}
+
+	for _, r := range f.include {
+		if r.matches(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListFilters narrows what is asked from docker; include rules may select unlabeled containers
+// and docker can't match any one of several labels, so then every container has to be looked at
+func (f Filter) ListFilters() filters.Args {
+	if len(f.include) > 0 || len(f.conventions.scrape) > 1 {
+		return filters.NewArgs()
+	}
+	return filters.NewArgs(filters.Arg("label", scrapeTargetLabel+"=true"))
+}
+
+// ContainerSubject describes a container from a list
+func ContainerSubject(container types.Container) Subject {
+	s := Subject{
+		image:    container.Image,
+		labels:   container.Labels,
+		networks: []string{},
+	}
+	if len(container.Names) > 0 {
+		s.name = container.Names[0]
+	}
+	if container.NetworkSettings != nil {
+		for network := range container.NetworkSettings.Networks {
+			s.networks = append(s.networks, network)
+		}
+	}
+	return s
+}
+
+// InspectSubject describes an inspected container
+func InspectSubject(inspect types.ContainerJSON) Subject {
+	s := Subject{
+		name:     inspect.Name,
+		networks: []string{},
+	}
+	if inspect.Config != nil {
+		s.image = inspect.Config.Image
+		s.labels = inspect.Config.Labels
+	}
+	if inspect.NetworkSettings != nil {
+		for network := range inspect.NetworkSettings.Networks {
+			s.networks = append(s.networks, network)
+		}
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package producer
+
+import (
+	"strings"
+	"text/template"
+)
+
+const (
+	ComposeServiceLabel = "com.docker.compose.service"
+	composeProjectLabel = "com.docker.compose.project"
+	composeNumberLabel  = "com.docker.compose.container-number"
+	swarmServiceLabel   = "com.docker.swarm.service.name"
+	jobLabel            = "scrape_job"
+
+	// templateSource names the job with identity.template; it is configured rather than built in
+	templateSource = "template"
+)
+
+type identitySource func(identity ContainerIdentity) string
+
+var identitySources = map[string]identitySource{
+	"label": func(id ContainerIdentity) string {
+		return id.Labels[jobLabel]
+	},
+	"compose_service": func(id ContainerIdentity) string {
+		return id.Labels[ComposeServiceLabel]
+	},
+	"swarm_service": func(id ContainerIdentity) string {
+		return id.Labels[swarmServiceLabel]
+	},
+	"container_name": func(id ContainerIdentity) string {
+		return strings.TrimPrefix(id.Name, "/")
+	},
+	"image": func(id ContainerIdentity) string {
+		return shortImage(id.Image)
+	},
+}
+
+func shortImage(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	if i := strings.Index(image, ":"); i >= 0 {
+		image = image[:i]
+	}
+	return image
+}
+
+// DefaultIdentityPrecedence is the order used when none is configured
+var DefaultIdentityPrecedence = []string{"label", "compose_service", "swarm_service", "container_name", "image"}
+
+// ContainerIdentity is what a job name is derived from
+type ContainerIdentity struct {
+	ID     string
+	Labels map[string]string
+	Name   string
+	Image  string
+}
+
+// identityFields is what a job name template can refer to
+type identityFields struct {
+	ID             string
+	Name           string
+	Image          string
+	Service        string
+	ComposeProject string
+	Number         string
+	Labels         map[string]string
+}
+
+// JobNamer derives job names from the first identity source that yields a value,
+// the order being overridable per tenant (compose project)
+type JobNamer struct {
+	precedence []string
+	tenants    map[string][]string
+	template   *template.Template
+}
+
+// NewJobNamer builds a namer from a validated cfg
+func NewJobNamer(cfg IdentityConfig) JobNamer {
+	n := JobNamer{precedence: cfg.Precedence, tenants: cfg.Tenants}
+	if cfg.Template == "" {
+		return n
+	}
+
+	n.template = template.Must(template.New("job").Option("missingkey=zero").Parse(cfg.Template))
+	// a template that isn't placed explicitly comes right after the scrape_job label
+	if !contains(n.precedence, templateSource) {
+		precedence := []string{}
+		for _, source := range n.precedence {
+			precedence = append(precedence, source)
+			if source == "label" {
+				precedence = append(precedence, templateSource)
+			}
+		}
+		if !contains(precedence, templateSource) {
+			precedence = append([]string{templateSource}, precedence...)
+		}
+		n.precedence = precedence
+	}
+	return n
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Name is the job name of id, from the first source in precedence that yields one
+func (n JobNamer) Name(id ContainerIdentity) string {
+	precedence := n.precedence
+	if tenant, ok := n.tenants[id.Labels[composeProjectLabel]]; ok {
+		precedence = tenant
+	}
+
+	for _, source := range precedence {
+		if name := n.fromSource(source, id); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (n JobNamer) fromSource(source string, id ContainerIdentity) string {
+	if source != templateSource {
+		return identitySources[source](id)
+	}
+	if n.template == nil {
+		return ""
+	}
+
+	service := id.Labels[ComposeServiceLabel]
+	if service == "" {
+		service = id.Labels[swarmServiceLabel]
+	}
+	shortID := id.ID
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+
+	var b strings.Builder
+	err := n.template.Execute(&b, identityFields{
+		ID:             shortID,
+		Name:           strings.TrimPrefix(id.Name, "/"),
+		Image:          shortImage(id.Image),
+		Service:        service,
+		ComposeProject: id.Labels[composeProjectLabel],
+		Number:         id.Labels[composeNumberLabel],
+		Labels:         id.Labels,
+	})
+	if err != nil {
+		return ""
+	}
+	// separators left dangling by empty fields are dropped, e.g. "-web" for a container outside compose
+	return strings.Trim(b.String(), "-_. ")
+}
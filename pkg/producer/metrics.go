@@ -0,0 +1,41 @@
+package producer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "target_explorer"
+
+var (
+	eventsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_produced_total",
+		Help:      "Container events pushed to the event log, by producer and action.",
+	}, []string{"producer", "action"})
+
+	containerEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_events_total",
+		Help:      "Docker container lifecycle events observed for scrape targets, by action and service.",
+	}, []string{"action", "service"})
+
+	producerUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "producer_up",
+		Help:      "Whether a producer is currently running, by producer.",
+	}, []string{"producer"})
+
+	producerRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "producer_restarts_total",
+		Help:      "Producers restarted by the supervisor after a panic or an unexpected return, by producer.",
+	}, []string{"producer"})
+
+	// DockerErrors is shared with the publisher, which calls the engine too
+	DockerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "docker_api_errors_total",
+		Help:      "Failed Docker API calls, by operation.",
+	}, []string{"operation"})
+)
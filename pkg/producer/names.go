@@ -0,0 +1,48 @@
+package producer
+
+import (
+	"sync"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+// nameCache remembers the job each container was announced under, so its stop and die events
+// name the same job even when their attributes alone would derive a different name or none at all
+type nameCache struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func newNameCache() *nameCache {
+	return &nameCache{names: make(map[string]string)}
+}
+
+func (nc *nameCache) resolve(e eventlog.Event) eventlog.Event {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if e.Up {
+		if e.Name != "" {
+			nc.names[e.ContainerID] = e.Name
+		}
+		return e
+	}
+
+	if name, ok := nc.names[e.ContainerID]; ok {
+		e.Name = name
+	}
+	return e
+}
+
+// retain drops containers that are no longer running; stop and die events arrive in either
+// order, so neither of them can evict an entry on its own
+func (nc *nameCache) retain(running map[string]bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	for id := range nc.names {
+		if !running[id] {
+			delete(nc.names, id)
+		}
+	}
+}
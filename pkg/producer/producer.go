@@ -0,0 +1,223 @@
+// Package producer watches the Docker engine, through periodic scans and the event stream,
+// and records which containers should be scrape targets in an eventlog.Log.
+package producer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+var (
+	ErrProducerReceiveEvent = fmt.Errorf("producer receiving event")
+
+	errRulesChanged = fmt.Errorf("producer rules changed")
+)
+
+const (
+	streamInitialBackoff = time.Second
+	streamMaxBackoff     = 30 * time.Second
+)
+
+type producer interface {
+	produceEventsFor(context.Context, *eventlog.Log)
+}
+
+type producerType int
+
+// Reporter is told how the producers are doing, for the health endpoints
+type Reporter interface {
+	StreamUp()
+	StreamDown()
+	ProducerStarted(name string)
+	ProducerStopped(name, state string, err error)
+}
+
+const (
+	scraper producerType = iota + 1
+	eventStreamer
+)
+
+// Manager runs the scraper and the event streamer that feed the event log
+type Manager struct {
+	logger       *logrus.Logger
+	reporter     Reporter
+	producers    map[producerType]producer
+	rules        *rules
+	scanInterval time.Duration
+}
+
+// New wires the producers; nothing runs until Run
+func New(logger *logrus.Logger, docker Docker, h Reporter, namer JobNamer, filter Filter, policy Policy, cfg Config) Manager {
+	producers := make(map[producerType]producer)
+
+	names := newNameCache()
+	r := newRules(namer, filter, policy)
+	s := scraperImpl{logger, docker, r, names}
+	producers[scraper] = s
+	producers[eventStreamer] = eventStreamerImpl{logger, docker, r, names, s, h}
+
+	return Manager{
+		logger:       logger,
+		reporter:     h,
+		producers:    producers,
+		rules:        r,
+		scanInterval: cfg.ScanInterval,
+	}
+}
+
+type scraperImpl struct {
+	logger *logrus.Logger
+	docker Docker
+	rules  *rules
+	names  *nameCache
+}
+
+func (s scraperImpl) produceEventsFor(ctx context.Context, el *eventlog.Log) {
+	// stamped before listing, so a stop streamed while the list is in flight still sorts after it
+	listedAt := time.Now()
+	namer, filter, _ := s.rules.get()
+	containers, err := s.docker.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		s.logger.Errorf("%v: %s", ErrProducerReceiveEvent, err)
+		DockerErrors.WithLabelValues("container_list").Inc()
+		return
+	}
+
+	running := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		running[container.ID] = true
+	}
+	s.names.retain(running)
+
+	for _, container := range containers {
+		if !filter.Selects(ContainerSubject(container)) {
+			continue
+		}
+
+		eventsProduced.WithLabelValues("scraper", eventlog.Running.String()).Inc()
+		el.Push(s.names.resolve(eventlog.Event{
+			Action:      eventlog.Running,
+			Up:          true,
+			ContainerID: container.ID,
+			Name:        namer.Name(ContainerIdentity{container.ID, container.Labels, container.Names[0], container.Image}),
+			RecordedAt:  listedAt,
+		}))
+	}
+}
+
+type eventStreamerImpl struct {
+	logger  *logrus.Logger
+	docker  Docker
+	rules   *rules
+	names   *nameCache
+	catchUp producer
+	health  Reporter
+}
+
+func (es eventStreamerImpl) produceEventsFor(ctx context.Context, el *eventlog.Log) {
+	backoff := streamInitialBackoff
+	rescan := ""
+
+	for {
+		received, err := es.stream(ctx, el, rescan)
+		es.health.StreamDown()
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = streamInitialBackoff
+		}
+		if err == errRulesChanged {
+			rescan = "docker event stream resubscribed, scanning for containers the new producer rules select"
+			continue
+		}
+
+		es.logger.Errorf("%v: %s", ErrProducerReceiveEvent, err)
+		DockerErrors.WithLabelValues("events").Inc()
+		es.logger.Warnf("docker event stream lost, reconnecting in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+		rescan = "docker event stream reconnected, scanning for containers started during the outage"
+	}
+}
+
+// stream pushes events until the subscription fails or the rules change, reporting whether any event made it through;
+// a non-empty rescan is logged and followed by a scan
+func (es eventStreamerImpl) stream(ctx context.Context, el *eventlog.Log, rescan string) (bool, error) {
+	namer, filter, policy := es.rules.get()
+	args := filter.ListFilters()
+	args.Add("type", "container")
+	for _, action := range policy.actions() {
+		args.Add("event", action)
+	}
+
+	// ending the subscription when the rules change must release it as well
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgEvents, errEvents := es.docker.Events(ctx, types.EventsOptions{Filters: args})
+
+	es.health.StreamUp()
+
+	// the scan runs after subscribing so containers started in between are seen by at least one of the two
+	if rescan != "" {
+		es.logger.Info(rescan)
+		es.catchUp.produceEventsFor(ctx, el)
+	}
+
+	received := false
+	for {
+		select {
+		case <-ctx.Done():
+			return received, ctx.Err()
+		case msg := <-msgEvents:
+			received = true
+			attrs := msg.Actor.Attributes
+			if !filter.Selects(Subject{name: attrs["name"], image: attrs["image"], labels: attrs}) {
+				continue
+			}
+
+			action := eventlog.ParseAction(msg.Action)
+			behavior := policy.behavior(action)
+			if behavior == BehaviorIgnore {
+				continue
+			}
+			name := namer.Name(ContainerIdentity{msg.Actor.ID, attrs, attrs["name"], attrs["image"]})
+
+			recordedAt := time.Now()
+			if msg.TimeNano != 0 {
+				recordedAt = time.Unix(0, msg.TimeNano)
+			}
+
+			e := es.names.resolve(eventlog.Event{
+				Action:      action,
+				Up:          behavior == BehaviorAdd,
+				ContainerID: msg.Actor.ID,
+				Name:        name,
+				RecordedAt:  recordedAt,
+			})
+
+			eventsProduced.WithLabelValues("event_streamer", action.String()).Inc()
+			containerEvents.WithLabelValues(msg.Action, e.Name).Inc()
+			el.Push(e)
+		case err := <-errEvents:
+			return received, err
+		case <-es.rules.changed:
+			return received, errRulesChanged
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package producer
+
+import "sync"
+
+// rules are what the producers select and name containers by; Apply swaps them as a whole
+// so a scan or a subscription never mixes the old and the new
+type rules struct {
+	mu     sync.RWMutex
+	namer  JobNamer
+	filter Filter
+	policy Policy
+
+	// changed wakes the event streamer, whose subscription was narrowed by the old rules
+	changed chan struct{}
+}
+
+func newRules(namer JobNamer, filter Filter, policy Policy) *rules {
+	return &rules{
+		namer:   namer,
+		filter:  filter,
+		policy:  policy,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (r *rules) get() (JobNamer, Filter, Policy) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.namer, r.filter, r.policy
+}
+
+func (r *rules) set(namer JobNamer, filter Filter, policy Policy) {
+	r.mu.Lock()
+	r.namer, r.filter, r.policy = namer, filter, policy
+	r.mu.Unlock()
+
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Apply makes the running producers select, name and treat events by the new rules; the
+// event stream is resubscribed and caught up with a scan
+func (pm Manager) Apply(namer JobNamer, filter Filter, policy Policy) {
+	pm.rules.set(namer, filter, policy)
+}
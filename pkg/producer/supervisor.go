@@ -0,0 +1,126 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+// ErrProducerCrashed is returned for a producer that panicked
+var ErrProducerCrashed = fmt.Errorf("producer crashed")
+
+const (
+	supervisorInitialBackoff = time.Second
+	supervisorMaxBackoff     = time.Minute
+	// a producer that ran this long before failing restarts from the initial backoff
+	supervisorStableAfter = time.Minute
+
+	StateRunning    = "running"
+	StateWaiting    = "waiting"
+	StateRestarting = "restarting"
+	StateStopped    = "stopped"
+)
+
+// Status is a producer's state as reported to the Reporter
+type Status struct {
+	State     string    `json:"state"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+}
+
+// Run starts every producer in its own goroutine and returns once all of them stopped with ctx
+func (pm Manager) Run(ctx context.Context, el *eventlog.Log) {
+	var wg sync.WaitGroup
+	for p, prod := range pm.producers {
+		wg.Add(1)
+		go func(p producerType, prod producer) {
+			defer wg.Done()
+			pm.supervise(ctx, p, prod, el)
+		}(p, prod)
+	}
+	wg.Wait()
+}
+
+// supervise keeps a producer going until ctx is done: the scraper runs again every scan
+// interval, and a producer that panics or gives up is restarted with backoff
+func (pm Manager) supervise(ctx context.Context, p producerType, prod producer, el *eventlog.Log) {
+	name := p.String()
+	log := pm.logger.WithField("producer", name)
+	backoff := supervisorInitialBackoff
+
+	for {
+		pm.reporter.ProducerStarted(name)
+		producerUp.WithLabelValues(name).Set(1)
+		started := time.Now()
+
+		err := runProducer(ctx, log, prod, el)
+		producerUp.WithLabelValues(name).Set(0)
+		if ctx.Err() != nil {
+			pm.reporter.ProducerStopped(name, StateStopped, nil)
+			return
+		}
+
+		var wait time.Duration
+		switch {
+		case err == nil && p == scraper:
+			if pm.scanInterval == 0 {
+				pm.reporter.ProducerStopped(name, StateStopped, nil)
+				return
+			}
+			pm.reporter.ProducerStopped(name, StateWaiting, nil)
+			wait = pm.scanInterval
+		default:
+			if err == nil {
+				err = fmt.Errorf("%v: returned while the agent is running", ErrProducerCrashed)
+			}
+			if time.Since(started) > supervisorStableAfter {
+				backoff = supervisorInitialBackoff
+			}
+			log.Errorf("%s, restarting in %s", err, backoff)
+			producerRestarts.WithLabelValues(name).Inc()
+			pm.reporter.ProducerStopped(name, StateRestarting, err)
+
+			wait = backoff
+			backoff *= 2
+			if backoff > supervisorMaxBackoff {
+				backoff = supervisorMaxBackoff
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			pm.reporter.ProducerStopped(name, StateStopped, nil)
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runProducer turns a panic in a producer into an error, keeping the rest of the agent alive
+func runProducer(ctx context.Context, log *logrus.Entry, prod producer, el *eventlog.Log) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("stack", string(debug.Stack())).Error("producer panicked")
+			err = fmt.Errorf("%v: %v", ErrProducerCrashed, r)
+		}
+	}()
+	prod.produceEventsFor(ctx, el)
+	return nil
+}
+
+func (p producerType) String() string {
+	switch p {
+	case scraper:
+		return "scraper"
+	case eventStreamer:
+		return "event_streamer"
+	}
+	return "unknown"
+}
@@ -0,0 +1,50 @@
+package publisher
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	addressFamilyIPv4 = "ipv4"
+	addressFamilyIPv6 = "ipv6"
+)
+
+// hostBinding picks the binding of a published port to scrape: the first one of the preferred
+// family, or the first one there is; dual-stack hosts publish a port on 0.0.0.0 and :: alike
+func hostBinding(bindings []nat.PortBinding, family string) nat.PortBinding {
+	for _, b := range bindings {
+		if family != "" && bindingFamily(b) == family {
+			return b
+		}
+	}
+	return bindings[0]
+}
+
+func bindingFamily(b nat.PortBinding) string {
+	ip := net.ParseIP(b.HostIP)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return addressFamilyIPv4
+	}
+	return addressFamilyIPv6
+}
+
+// bindingHost is the address a port bound to one specific interface is reachable on; ports
+// bound to every interface, or only to loopback, are scraped through the advertised host
+func bindingHost(b nat.PortBinding) string {
+	ip := net.ParseIP(b.HostIP)
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return ""
+	}
+	return ip.String()
+}
+
+// targetAddress joins host and port, bracketing IPv6 literals
+func targetAddress(host, port string) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port)
+}
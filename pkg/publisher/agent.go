@@ -0,0 +1,277 @@
+// Package publisher consumes the event log and publishes the resulting targets as
+// Prometheus configuration, along with the agent's HTTP API, health and metrics.
+package publisher
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+// agentFlags are the flags of every command that loads the agent config
+type agentFlags struct {
+	configPath      *string
+	consumeInterval *time.Duration
+	consumeJitter   *time.Duration
+	logFormat       *string
+	logLevel        *string
+}
+
+func newAgentFlags(fs *flag.FlagSet) agentFlags {
+	return agentFlags{
+		configPath:      fs.String("config", "", "path to the agent configuration file"),
+		consumeInterval: fs.Duration("consume-interval", 0, "fallback consume interval, overrides the config file"),
+		consumeJitter:   fs.Duration("consume-jitter", 0, "maximum random delay added to each consume interval, overrides the config file"),
+		logFormat:       fs.String("log-format", "", "log output format, text or json, overrides the config file"),
+		logLevel:        fs.String("log-level", "", "log level, overrides the config file"),
+	}
+}
+
+func (f agentFlags) load() (Config, error) {
+	cfg, err := LoadConfig(*f.configPath)
+	if err != nil {
+		return cfg, err
+	}
+	if *f.consumeInterval != 0 {
+		cfg.Consume.Interval = *f.consumeInterval
+	}
+	if *f.consumeJitter != 0 {
+		cfg.Consume.Jitter = *f.consumeJitter
+	}
+	if *f.logFormat != "" {
+		cfg.Log.Format = *f.logFormat
+	}
+	if *f.logLevel != "" {
+		cfg.Log.Level = *f.logLevel
+	}
+	return cfg, nil
+}
+
+// Agent is the whole discovery pipeline: the producers feeding the event log and the
+// consumer publishing its targets. Embedders build one from a validated Config
+type Agent struct {
+	logger    *logrus.Logger
+	cfg       Config
+	events    *eventlog.Log
+	health    *health
+	debug     *debugState
+	store     *store
+	producers producer.Manager
+	consumer  *consumer
+	tracing   *sdktrace.TracerProvider
+
+	load     func() (Config, error)
+	reloadMu sync.Mutex
+}
+
+// NewAgent connects to Docker and opens the outputs and the state store; cfg must have
+// passed Validate
+func NewAgent(logger *logrus.Logger, cfg Config) (*Agent, error) {
+	docker, version, err := dialDocker(logger, cfg.Docker)
+	if err != nil {
+		return nil, err
+	}
+	return newAgent(logger, cfg, docker, version.Version, docker.ClientVersion())
+}
+
+// NewAgentWith runs the pipeline against docker instead of the engine cfg points at,
+// e.g. a dockerfake.Engine
+func NewAgentWith(logger *logrus.Logger, cfg Config, docker producer.Docker) (*Agent, error) {
+	ping, err := docker.Ping(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrDockerConnect, err)
+	}
+	return newAgent(logger, cfg, docker, "", ping.APIVersion)
+}
+
+func newAgent(logger *logrus.Logger, cfg Config, docker producer.Docker, engineVersion, apiVersion string) (*Agent, error) {
+	sinks, err := newSinks(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var st *store
+	if cfg.State.Path != "" {
+		st, err = openStore(cfg.State.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tp *sdktrace.TracerProvider
+	if cfg.Tracing.Endpoint != "" {
+		tp, err = newTracerProvider(cfg.Tracing)
+		if err != nil {
+			if st != nil {
+				st.close()
+			}
+			return nil, err
+		}
+	}
+
+	h := newHealth(docker, engineVersion, apiVersion)
+	dbg := newDebugState(cfg)
+	filter := producer.NewFilter(cfg.Filters, producer.NewConventions(cfg.Compat, cfg.Credentials))
+	return &Agent{
+		logger:    logger,
+		cfg:       cfg,
+		events:    eventlog.New(),
+		health:    h,
+		debug:     dbg,
+		store:     st,
+		producers: producer.New(logger, docker, h, producer.NewJobNamer(cfg.Identity), filter, producer.NewPolicy(cfg.Events), cfg.Producers),
+		consumer:  newConsumer(logger, docker, sinks, h, st, dbg, cfg),
+		tracing:   tp,
+	}, nil
+}
+
+// Close releases the state store and flushes the spans not exported yet; call it once Run
+// or Once returned
+func (a *Agent) Close() {
+	if a.store != nil {
+		a.store.close()
+	}
+	if a.tracing != nil {
+		err := shutdownTracing(a.tracing)
+		if err != nil {
+			a.logger.Errorf("%v: %s", ErrTracingShutdown, err)
+		}
+	}
+}
+
+// Run serves the HTTP and gRPC APIs and keeps the outputs up to date until ctx is done,
+// then waits for the producers and publishes whatever they recorded last
+func (a *Agent) Run(ctx context.Context) {
+	c := a.consumer
+	api := newAPI(a.events, a.debug, c.control, a.ReloadConfig, a.cfg.API)
+	if a.cfg.ListenAddress != "" {
+		go newServer(a.logger, a.cfg.ListenAddress, a.health, api).run(ctx)
+	}
+	if a.cfg.GRPC.ListenAddress != "" {
+		go newGRPCServer(a.logger, a.cfg.GRPC, api).run(ctx)
+	}
+
+	producersDone := make(chan struct{})
+	go func() {
+		a.producers.Run(ctx, a.events)
+		close(producersDone)
+	}()
+
+	c.run(ctx, a.events)
+	a.logger.Info("shutting down, waiting for producers to stop")
+	<-producersDone
+
+	// ctx is done already, the last cycle must not be cut short by it
+	c.consume(context.Background(), a.events)
+}
+
+// Once scans the running containers and publishes their targets a single time
+func (a *Agent) Once(ctx context.Context) error {
+	return a.consumer.once(ctx)
+}
+
+// RunCommand watches containers and keeps the Prometheus config up to date until signaled
+func RunCommand(logger *logrus.Logger, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	flags := newAgentFlags(fs)
+	pprofAddr := fs.String("pprof-addr", "", "address to expose net/http/pprof on, disabled when empty")
+	dryRun := fs.Bool("dry-run", false, "print the config and target changes instead of writing prometheus.yaml and reloading")
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	cfg.DryRun = *dryRun
+
+	err = cfg.Validate()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	configureLogger(logger, cfg.Log)
+
+	ag, err := NewAgent(logger, cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer ag.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *pprofAddr != "" {
+		go newPprofServer(logger, *pprofAddr).run(ctx)
+	}
+
+	ag.SetConfigLoader(flags.load)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				logger.Info("received SIGHUP, reloading the config")
+				_, err := ag.ReloadConfig(ctx)
+				if err != nil {
+					logger.Error(err)
+				}
+			}
+		}
+	}()
+
+	ag.Run(ctx)
+	logger.Info("shutdown complete")
+}
+
+// OnceCommand is a single full scan and publish for cron jobs and provisioning scripts;
+// it exits non-zero if anything along the way failed
+func OnceCommand(logger *logrus.Logger, args []string) {
+	fs := flag.NewFlagSet("once", flag.ExitOnError)
+	flags := newAgentFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "print the config and target changes instead of writing prometheus.yaml and reloading")
+	noReload := fs.Bool("no-reload", false, "write the targets without asking Prometheus to reload")
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	cfg.DryRun = *dryRun
+	cfg.NoReload = *noReload
+
+	err = cfg.Validate()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	configureLogger(logger, cfg.Log)
+
+	ag, err := NewAgent(logger, cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = ag.Once(ctx)
+	stop()
+	ag.Close()
+
+	if err != nil {
+		logger.Errorf("once failed: %s", err)
+		os.Exit(1)
+	}
+	logger.Info("targets published")
+}
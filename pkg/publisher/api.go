@@ -0,0 +1,482 @@
+package publisher
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+var (
+	ErrAPIQuery        = fmt.Errorf("api parsing query")
+	ErrAPINotPublished = fmt.Errorf("api excluding job that is not published")
+	ErrAPINotManaged   = fmt.Errorf("api excluding job that is not managed by the agent")
+	ErrAPIUnauthorized = fmt.Errorf("api authorizing request")
+	ErrAPIForbidden    = fmt.Errorf("api serving a remote client without an api token_file")
+)
+
+const (
+	apiDefaultLimit     = 100
+	apiMaxLimit         = 1000
+	apiDefaultExclusion = time.Hour
+
+	excludeReason = "excluded"
+)
+
+// exclusion takes a job out of the published targets until the given time; a zero time lifts it
+type exclusion struct {
+	job   string
+	until time.Time
+}
+
+// control carries API requests into the consumer goroutine, which alone owns the target state
+type control struct {
+	reconcile chan struct{}
+	exclude   chan exclusion
+	reload    chan reloadRequest
+}
+
+func newControl() control {
+	return control{
+		reconcile: make(chan struct{}, 1),
+		exclude:   make(chan exclusion, 16),
+		reload:    make(chan reloadRequest),
+	}
+}
+
+type api struct {
+	events  *eventlog.Log
+	debug   *debugState
+	control control
+	reload  func(context.Context) ([]string, error)
+	cfg     apiConfig
+}
+
+type apiTarget struct {
+	Job string `json:"job"`
+	debugTarget
+}
+
+type apiQuarantined struct {
+	Job string `json:"job"`
+	debugQuarantined
+}
+
+type listResponse struct {
+	Total  int                      `json:"total"`
+	Offset int                      `json:"offset"`
+	Limit  int                      `json:"limit"`
+	Items  []map[string]interface{} `json:"items"`
+}
+
+// listQuery is shared by every list endpoint: label=name=value filters match a field of the
+// item or one of its labels, fields=a,b trims the items to those fields
+type listQuery struct {
+	limit   int
+	offset  int
+	fields  []string
+	filters map[string]string
+}
+
+func newAPI(el *eventlog.Log, dbg *debugState, ctl control, reload func(context.Context) ([]string, error), cfg apiConfig) *api {
+	return &api{el, dbg, ctl, reload, cfg}
+}
+
+func (a *api) register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/targets", a.handleTargets)
+	mux.HandleFunc("/api/targets/", a.guard(a.handleExclude))
+	mux.HandleFunc("/api/events", a.handleEvents)
+	mux.HandleFunc("/api/changes", a.handleChanges)
+	mux.HandleFunc("/api/quarantine", a.handleQuarantine)
+	mux.HandleFunc("/api/reconcile", a.guard(a.handleReconcile))
+	mux.HandleFunc("/api/config/reload", a.guard(a.handleConfigReload))
+	mux.HandleFunc(debugBundlePath, a.guard(a.debug.handleBundle))
+}
+
+// guard serves the endpoints that change the targets or hand out the config only to
+// authorized clients
+func (a *api) guard(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		err := a.authorize(token, r.RemoteAddr)
+		if err == ErrAPIForbidden {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// authorize checks the bearer token against the token_file, read on every request so a rotated
+// token applies right away; without a token_file only clients on the loopback interface pass
+func (a *api) authorize(token, remoteAddr string) error {
+	if a.cfg.TokenFile == "" {
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			return ErrAPIForbidden
+		}
+		return nil
+	}
+
+	want, err := readSecret("", a.cfg.TokenFile)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrAPIUnauthorized, err)
+	}
+	if want == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return fmt.Errorf("%v: invalid bearer token", ErrAPIUnauthorized)
+	}
+	return nil
+}
+
+func (a *api) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	published := a.debug.published()
+	items := make([]interface{}, 0, len(published))
+	for _, job := range sortedKeys(published) {
+		items = append(items, apiTarget{job, published[job]})
+	}
+	writeList(w, r, items)
+}
+
+func (a *api) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	pending := a.events.Peek()
+	items := make([]interface{}, 0, len(pending))
+	for _, e := range pending {
+		items = append(items, newDebugEvent(e))
+	}
+	writeList(w, r, items)
+}
+
+func (a *api) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	changes := a.debug.recentChanges()
+	items := make([]interface{}, 0, len(changes))
+	for _, ch := range changes {
+		items = append(items, ch)
+	}
+	writeList(w, r, items)
+}
+
+func (a *api) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	quarantined := a.debug.quarantined()
+	items := make([]interface{}, 0, len(quarantined))
+	for _, job := range sortedKeys(quarantined) {
+		items = append(items, apiQuarantined{job, quarantined[job]})
+	}
+	writeList(w, r, items)
+}
+
+func (a *api) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	a.requestReconcile()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// handleConfigReload re-reads the agent config and answers once the targets were reconciled
+// under it, listing the changed settings that need a restart
+func (a *api) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	restart, err := a.reload(r.Context())
+	if err == ErrConfigNoLoader {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "reloaded", "restart_required": restart})
+}
+
+// handleExclude serves POST and DELETE on /api/targets/{job}/exclude
+func (a *api) handleExclude(w http.ResponseWriter, r *http.Request) {
+	job := strings.TrimPrefix(r.URL.Path, "/api/targets/")
+	if !strings.HasSuffix(job, "/exclude") {
+		http.NotFound(w, r)
+		return
+	}
+	job = strings.TrimSuffix(job, "/exclude")
+
+	var duration time.Duration
+	switch r.Method {
+	case http.MethodPost:
+		duration = apiDefaultExclusion
+		if v := r.URL.Query().Get("for"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("%v: invalid duration %q", ErrAPIQuery, v), http.StatusBadRequest)
+				return
+			}
+			duration = d
+		}
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ex, err := a.requestExclusion(r.Context(), job, duration)
+	switch err {
+	case nil:
+	case ErrAPINotPublished:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case ErrAPINotManaged:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		// the request ended before the consumer took the exclusion
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	resp := map[string]interface{}{"job": job}
+	if !ex.until.IsZero() {
+		resp["until"] = ex.until
+	}
+	writeJSON(w, http.StatusAccepted, resp)
+}
+
+func (a *api) requestReconcile() {
+	// a reconcile that is already queued covers this request too
+	select {
+	case a.control.reconcile <- struct{}{}:
+	default:
+	}
+}
+
+// requestExclusion hands an exclusion to the consumer; a zero duration lifts it instead
+func (a *api) requestExclusion(ctx context.Context, job string, duration time.Duration) (exclusion, error) {
+	ex := exclusion{job: job}
+	if duration > 0 {
+		t, ok := a.debug.published()[job]
+		if !ok {
+			return ex, ErrAPINotPublished
+		}
+		if !t.Managed {
+			return ex, ErrAPINotManaged
+		}
+		ex.until = time.Now().Add(duration)
+	}
+
+	select {
+	case a.control.exclude <- ex:
+		return ex, nil
+	case <-ctx.Done():
+		return ex, ctx.Err()
+	}
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func parseListQuery(r *http.Request) (listQuery, error) {
+	values := r.URL.Query()
+	q := listQuery{limit: apiDefaultLimit, filters: make(map[string]string)}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > apiMaxLimit {
+			return q, fmt.Errorf("%v: limit must be between 1 and %d", ErrAPIQuery, apiMaxLimit)
+		}
+		q.limit = limit
+	}
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return q, fmt.Errorf("%v: offset must be a non-negative integer", ErrAPIQuery)
+		}
+		q.offset = offset
+	}
+	if v := values.Get("fields"); v != "" {
+		q.fields = strings.Split(v, ",")
+	}
+	for _, filter := range values["label"] {
+		name, value, ok := strings.Cut(filter, "=")
+		if !ok || name == "" {
+			return q, fmt.Errorf("%v: label filter %q must be name=value", ErrAPIQuery, filter)
+		}
+		q.filters[name] = value
+	}
+	return q, nil
+}
+
+func (q listQuery) matches(item map[string]interface{}) bool {
+	labels, _ := item["labels"].(map[string]interface{})
+	for name, value := range q.filters {
+		if fmt.Sprint(item[name]) != value && fmt.Sprint(labels[name]) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (q listQuery) selectFields(item map[string]interface{}) map[string]interface{} {
+	if len(q.fields) == 0 {
+		return item
+	}
+
+	selected := make(map[string]interface{}, len(q.fields))
+	for _, field := range q.fields {
+		if value, ok := item[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
+
+func writeList(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	matched := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var fields map[string]interface{}
+		err = json.Unmarshal(b, &fields)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if q.matches(fields) {
+			matched = append(matched, fields)
+		}
+	}
+
+	start, end := pageBounds(len(matched), q.offset, q.limit)
+	resp := listResponse{
+		Total:  len(matched),
+		Offset: q.offset,
+		Limit:  q.limit,
+		Items:  make([]map[string]interface{}, 0, end-start),
+	}
+	for _, item := range matched[start:end] {
+		resp.Items = append(resp.Items, q.selectFields(item))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func pageBounds(total, offset, limit int) (int, int) {
+	start, end := offset, offset+limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exclude applies a manual override from the API; an excluded job is removed right away and
+// ignored by events and reconciles until the exclusion lapses or is lifted
+func (c *consumer) exclude(ctx context.Context, ex exclusion) {
+	log := c.logger.WithField("job", ex.job)
+
+	if ex.until.IsZero() {
+		if _, ok := c.exclusions[ex.job]; ok {
+			log.Info("exclusion lifted")
+			delete(c.exclusions, ex.job)
+			c.reconcile(ctx)
+		}
+		return
+	}
+
+	log.Infof("excluding target until %s", ex.until.Format(time.RFC3339))
+	c.exclusions[ex.job] = ex.until
+
+	stateMap, err := c.state()
+	if err != nil {
+		c.logger.Errorf("%v: %s", ErrConsumerGetCurrentState, err)
+		return
+	}
+
+	t, ok := stateMap[ex.job]
+	if !ok || !t.managed {
+		return
+	}
+	c.track(changeRemove, ex.job, t, c.owners[ex.job], excludeReason)
+	delete(stateMap, ex.job)
+	c.commit(ctx, stateMap)
+}
+
+func (c *consumer) excluded(job string) bool {
+	until, ok := c.exclusions[job]
+	return ok && time.Now().Before(until)
+}
+
+// expireExclusions drops lapsed exclusions, reporting whether a reconcile is needed to bring the jobs back
+func (c *consumer) expireExclusions() bool {
+	lapsed := false
+	for job, until := range c.exclusions {
+		if time.Now().Before(until) {
+			continue
+		}
+		c.logger.WithField("job", job).Info("exclusion lapsed")
+		delete(c.exclusions, job)
+		lapsed = true
+	}
+	return lapsed
+}
@@ -0,0 +1,77 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteList(t *testing.T) {
+	items := make([]interface{}, 0, 250)
+	for i := 0; i < 250; i++ {
+		image := "nginx"
+		if i%2 == 1 {
+			image = "redis"
+		}
+		items = append(items, apiTarget{fmt.Sprintf("job-%03d", i), debugTarget{
+			Address: fmt.Sprintf("host.docker.internal:%d", 30000+i),
+			Labels:  map[string]string{"container_image": image},
+			Managed: i%5 != 0,
+		}})
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		total  int
+		jobs   []string
+		fields []string
+	}{
+		{query: "", status: http.StatusOK, total: 250, jobs: []string{"job-000", "job-099"}},
+		{query: "limit=2&offset=248", status: http.StatusOK, total: 250, jobs: []string{"job-248", "job-249"}},
+		{query: "offset=300", status: http.StatusOK, total: 250},
+		{query: "label=container_image=redis&limit=2", status: http.StatusOK, total: 125, jobs: []string{"job-001", "job-003"}},
+		{query: "label=container_image=redis&label=managed=false&limit=2", status: http.StatusOK, total: 25, jobs: []string{"job-005", "job-015"}},
+		{query: "label=job=job-007&fields=job,address", status: http.StatusOK, total: 1, jobs: []string{"job-007"}, fields: []string{"address", "job"}},
+		{query: "limit=0", status: http.StatusBadRequest},
+		{query: "limit=1001", status: http.StatusBadRequest},
+		{query: "offset=-1", status: http.StatusBadRequest},
+		{query: "label=container_image", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run("?"+tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeList(w, httptest.NewRequest(http.MethodGet, "/api/targets?"+tt.query, nil), items)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp listResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Total != tt.total {
+				t.Errorf("total %d, want %d", resp.Total, tt.total)
+			}
+			if len(tt.jobs) == 0 {
+				if len(resp.Items) != 0 {
+					t.Errorf("got %d items, want none", len(resp.Items))
+				}
+				return
+			}
+			if first, last := resp.Items[0]["job"], resp.Items[len(resp.Items)-1]["job"]; first != tt.jobs[0] || last != tt.jobs[len(tt.jobs)-1] {
+				t.Errorf("items run from %v to %v, want %s to %s", first, last, tt.jobs[0], tt.jobs[len(tt.jobs)-1])
+			}
+			if tt.fields != nil && len(resp.Items[0]) != len(tt.fields) {
+				t.Errorf("item has fields %v, want %v", resp.Items[0], tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+var ErrAuditWrite = fmt.Errorf("audit writing change records")
+
+const (
+	changeAdd    = "add"
+	changeUpdate = "update"
+	changeRemove = "remove"
+
+	reconcileReason = "reconcile"
+)
+
+type change struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Job         string    `json:"job"`
+	Target      string    `json:"target"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Reason      string    `json:"reason"`
+}
+
+type auditLog struct {
+	path string
+}
+
+func newAuditLog(path string) auditLog {
+	return auditLog{path}
+}
+
+func (a auditLog) record(changes []change) error {
+	if a.path == "" || len(changes) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrAuditWrite, err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, ch := range changes {
+		err = enc.Encode(ch)
+		if err != nil {
+			return fmt.Errorf("%v: %s", ErrAuditWrite, err)
+		}
+	}
+	return nil
+}
+
+// track queues a change until the target set containing it has been published
+func (c *consumer) track(action, job string, t target, containerID, reason string) {
+	c.changes = append(c.changes, change{
+		Time:        time.Now(),
+		Action:      action,
+		Job:         job,
+		Target:      t.address,
+		ContainerID: containerID,
+		Reason:      reason,
+	})
+}
+
+func (c *consumer) flushChanges() {
+	err := c.audit.record(c.changes)
+	if err != nil {
+		c.logger.Error(err)
+	}
+	c.changes = nil
+}
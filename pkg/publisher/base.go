@@ -0,0 +1,113 @@
+package publisher
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrOutputBaseConfig = fmt.Errorf("output reading base config")
+
+const scrapeConfigsKey = "scrape_configs"
+
+// baseConfig is a hand-written prometheus.yaml the managed scrape_configs are injected into;
+// every other section (global, alerting, rule_files, remote_write, ...) is carried through
+// as written, and its own scrape_configs stay ahead of the managed ones
+type baseConfig struct {
+	sections       yaml.MapSlice
+	scrapeConfigs  []interface{}
+	jobs           map[string]bool
+	scrapeInterval string
+}
+
+func loadBaseConfig(path string) (*baseConfig, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrOutputBaseConfig, err)
+	}
+
+	var sections yaml.MapSlice
+	err = yaml.Unmarshal(f, &sections)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s: %s", ErrOutputBaseConfig, path, err)
+	}
+	var typed prometheusConf
+	err = yaml.Unmarshal(f, &typed)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s: %s", ErrOutputBaseConfig, path, err)
+	}
+
+	b := &baseConfig{
+		sections:       sections,
+		jobs:           make(map[string]bool, len(typed.ScrapeConfigs)),
+		scrapeInterval: typed.Global.ScrapeInterval,
+	}
+	for _, item := range sections {
+		if item.Key != scrapeConfigsKey {
+			continue
+		}
+		jobs, ok := item.Value.([]interface{})
+		if !ok && item.Value != nil {
+			return nil, fmt.Errorf("%v: %s: scrape_configs must be a list", ErrOutputBaseConfig, path)
+		}
+		b.scrapeConfigs = jobs
+	}
+	for _, sc := range typed.ScrapeConfigs {
+		b.jobs[sc.JobName] = true
+	}
+	return b, nil
+}
+
+// scrapeInterval is the global scrape_interval the output's Prometheus runs with: its base
+// config's, or the one rendered without a base, which is also Prometheus' own default
+func (oc outputConfig) scrapeInterval() (time.Duration, error) {
+	interval := globalScrapeInterval
+	if oc.BaseConfig != "" {
+		base, err := loadBaseConfig(oc.BaseConfig)
+		if err != nil {
+			return 0, err
+		}
+		if base.scrapeInterval != "" {
+			interval = base.scrapeInterval
+		}
+	}
+
+	d, err := model.ParseDuration(interval)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %s: global scrape_interval: %s", ErrOutputBaseConfig, oc.BaseConfig, err)
+	}
+	return time.Duration(d), nil
+}
+
+// MarshalYAML lays the managed scrape_configs over the base config, keeping its sections in
+// the order they were written
+func (pc prometheusConf) MarshalYAML() (interface{}, error) {
+	type plain prometheusConf
+	if pc.base == nil {
+		return plain(pc), nil
+	}
+
+	jobs := make([]interface{}, 0, len(pc.base.scrapeConfigs)+len(pc.ScrapeConfigs))
+	jobs = append(jobs, pc.base.scrapeConfigs...)
+	for _, sc := range pc.ScrapeConfigs {
+		jobs = append(jobs, sc)
+	}
+
+	out := make(yaml.MapSlice, 0, len(pc.base.sections)+1)
+	injected := false
+	for _, item := range pc.base.sections {
+		if item.Key == scrapeConfigsKey {
+			item.Value = jobs
+			injected = true
+		}
+		out = append(out, item)
+	}
+	if !injected {
+		out = append(out, yaml.MapItem{Key: scrapeConfigsKey, Value: jobs})
+	}
+	return out, nil
+}
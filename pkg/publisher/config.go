@@ -0,0 +1,764 @@
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	ErrConfigRead    = fmt.Errorf("config reading file")
+	ErrConfigParse   = fmt.Errorf("config parsing file")
+	ErrConfigInvalid = producer.ErrConfigInvalid
+)
+
+const (
+	reloadEndpoint = "http://localhost:9090/-/reload"
+	reloadTimeout  = 500 * time.Millisecond
+
+	retryAttempts       = 5
+	retryInitialBackoff = 500 * time.Millisecond
+	retryMaxBackoff     = 10 * time.Second
+
+	consumeInterval = 60 * time.Second
+	consumeDebounce = 2 * time.Second
+
+	adaptiveBusyEvents = 10
+
+	reconcileInterval = 5 * time.Minute
+	scanInterval      = 10 * time.Minute
+
+	throttleMaxReloads     = 10
+	throttleReloadInterval = time.Minute
+	throttleFlapThreshold  = 6
+	throttleFlapWindow     = 2 * time.Minute
+
+	tracingSampleRatio = 1.0
+
+	listenAddress = ":2113"
+
+	logLevel = "info"
+
+	fileSDGCInterval = 5 * time.Minute
+
+	secretsDir = "/run/secrets"
+
+	hostMode    = "host"
+	sidecarMode = "sidecar"
+)
+
+// Config is the agent configuration, as read from the file passed via -config
+type Config struct {
+	DryRun   bool `yaml:"-"`
+	NoReload bool `yaml:"-"`
+
+	ListenAddress  string                     `yaml:"listen_address"`
+	API            apiConfig                  `yaml:"api"`
+	Log            logConfig                  `yaml:"log"`
+	Mode           string                     `yaml:"mode"`
+	Docker         dockerConfig               `yaml:"docker"`
+	TargetHost     string                     `yaml:"target_host"`
+	AddressFamily  string                     `yaml:"address_family"`
+	Reload         reloadConfig               `yaml:"reload"`
+	TargetLabels   []string                   `yaml:"target_labels"`
+	ExternalLabels map[string]string          `yaml:"external_labels"`
+	Retry          retryConfig                `yaml:"retry"`
+	Pipeline       pipelineConfig             `yaml:"pipeline"`
+	Consume        consumeConfig              `yaml:"consume"`
+	Output         outputConfig               `yaml:"output"`
+	Reconcile      reconcileConfig            `yaml:"reconcile"`
+	Producers      producer.Config            `yaml:"producers"`
+	Throttle       throttleConfig             `yaml:"throttle"`
+	Identity       producer.IdentityConfig    `yaml:"identity"`
+	Audit          auditConfig                `yaml:"audit"`
+	State          stateConfig                `yaml:"state"`
+	Resolver       resolverConfig             `yaml:"resolver"`
+	GRPC           grpcConfig                 `yaml:"grpc"`
+	Filters        producer.FiltersConfig     `yaml:"filters"`
+	Compat         producer.CompatConfig      `yaml:"compat"`
+	Events         producer.EventsConfig      `yaml:"events"`
+	Credentials    producer.CredentialsConfig `yaml:"credentials"`
+	Groups         map[string]groupConfig     `yaml:"groups"`
+	LeaderElection leaderElectionConfig       `yaml:"leader_election"`
+	Git            gitConfig                  `yaml:"git"`
+	Tracing        tracingConfig              `yaml:"tracing"`
+}
+
+// gitConfig names a local clone that the outputs are written into; every publish that changes
+// them is committed, and pushed when push is set
+type gitConfig struct {
+	Repo        string `yaml:"repo"`
+	Push        bool   `yaml:"push"`
+	Remote      string `yaml:"remote"`
+	Branch      string `yaml:"branch"`
+	AuthorName  string `yaml:"author_name"`
+	AuthorEmail string `yaml:"author_email"`
+}
+
+// leaderElectionConfig lets several agents share an output with only the leader publishing;
+// an empty backend disables it
+type leaderElectionConfig struct {
+	Backend       string        `yaml:"backend"`
+	LockFile      string        `yaml:"lock_file"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	Consul        consulConfig  `yaml:"consul"`
+}
+
+type consulConfig struct {
+	Address    string        `yaml:"address"`
+	Key        string        `yaml:"key"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+	TokenFile  string        `yaml:"token_file"`
+}
+
+// groupConfig is a Prometheus server fed with the targets whose scrape_group label names the group;
+// without a reload endpoint the agent leaves reloading it to someone else
+type groupConfig struct {
+	PrometheusConfig string        `yaml:"prometheus_config"`
+	BaseConfig       string        `yaml:"base_config"`
+	FileSDDir        string        `yaml:"file_sd_dir"`
+	Reload           *reloadConfig `yaml:"reload"`
+}
+
+// groupOutput is where the targets of one group, "" for unlabeled targets, are written
+type groupOutput struct {
+	group  string
+	output outputConfig
+	reload *reloadConfig
+}
+
+// apiConfig guards the control endpoints; without a token_file they only serve local clients
+type apiConfig struct {
+	TokenFile string `yaml:"token_file"`
+}
+
+type grpcConfig struct {
+	ListenAddress string        `yaml:"listen_address"`
+	TLS           grpcTLSConfig `yaml:"tls"`
+}
+
+// grpcTLSConfig serves gRPC over TLS once cert_file is set; clients must present a certificate
+// signed by client_ca_file when that is set too
+type grpcTLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+type resolverConfig struct {
+	ValidateTargets bool                `yaml:"validate_targets"`
+	Servers         []string            `yaml:"servers"`
+	Domains         map[string][]string `yaml:"domains"`
+}
+
+type stateConfig struct {
+	Path string `yaml:"path"`
+}
+
+type auditConfig struct {
+	Path string `yaml:"path"`
+}
+
+type reconcileConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// throttleConfig caps reloads per Prometheus and holds back containers that flap; a zero
+// max_reloads or flap_threshold turns that part off
+type throttleConfig struct {
+	MaxReloads     int           `yaml:"max_reloads"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	FlapThreshold  int           `yaml:"flap_threshold"`
+	FlapWindow     time.Duration `yaml:"flap_window"`
+}
+
+// tracingConfig exports the spans of every consume cycle and reconcile to an OTLP/gRPC
+// collector; an empty endpoint leaves tracing to whatever provider an embedder installed
+type tracingConfig struct {
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type logConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// dockerConfig points the agent at a daemon: unix:// and npipe:// sockets, tcp:// (with tls
+// material for daemons listening on 2376) or ssh://
+type dockerConfig struct {
+	Host       string          `yaml:"host"`
+	APIVersion string          `yaml:"api_version"`
+	TLS        dockerTLSConfig `yaml:"tls"`
+}
+
+type dockerTLSConfig struct {
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type outputConfig struct {
+	PrometheusConfig string        `yaml:"prometheus_config"`
+	BaseConfig       string        `yaml:"base_config"`
+	FileSDDir        string        `yaml:"file_sd_dir"`
+	GCInterval       time.Duration `yaml:"gc_interval"`
+}
+
+type consumeConfig struct {
+	Interval time.Duration  `yaml:"interval"`
+	Jitter   time.Duration  `yaml:"jitter"`
+	Debounce time.Duration  `yaml:"debounce"`
+	Adaptive adaptiveConfig `yaml:"adaptive"`
+}
+
+type adaptiveConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Min        time.Duration `yaml:"min"`
+	Max        time.Duration `yaml:"max"`
+	BusyEvents int           `yaml:"busy_events"`
+}
+
+type pipelineConfig struct {
+	Events  []string `yaml:"events"`
+	Targets []string `yaml:"targets"`
+}
+
+type reloadConfig struct {
+	Endpoint        string          `yaml:"endpoint"`
+	Timeout         time.Duration   `yaml:"timeout"`
+	BasicAuth       basicAuthConfig `yaml:"basic_auth"`
+	BearerToken     string          `yaml:"bearer_token"`
+	BearerTokenFile string          `yaml:"bearer_token_file"`
+	TLS             tlsConfig       `yaml:"tls"`
+}
+
+type retryConfig struct {
+	Attempts       int           `yaml:"attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+type basicAuthConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type tlsConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// DefaultConfig is what the agent runs with when no file is given
+func DefaultConfig() Config {
+	return Config{
+		ListenAddress: listenAddress,
+		Log: logConfig{
+			Format: textLogFormat,
+			Level:  logLevel,
+		},
+		Mode: hostMode,
+		Reload: reloadConfig{
+			Endpoint: reloadEndpoint,
+			Timeout:  reloadTimeout,
+		},
+		Retry: retryConfig{
+			Attempts:       retryAttempts,
+			InitialBackoff: retryInitialBackoff,
+			MaxBackoff:     retryMaxBackoff,
+		},
+		Pipeline: pipelineConfig{
+			Events:  defaultEventProcessors,
+			Targets: defaultTargetProcessors,
+		},
+		Consume: consumeConfig{
+			Interval: consumeInterval,
+			Debounce: consumeDebounce,
+			Adaptive: adaptiveConfig{
+				BusyEvents: adaptiveBusyEvents,
+			},
+		},
+		Identity: producer.IdentityConfig{
+			Precedence: producer.DefaultIdentityPrecedence,
+		},
+		Reconcile: reconcileConfig{
+			Interval: reconcileInterval,
+		},
+		Producers: producer.Config{
+			ScanInterval: scanInterval,
+		},
+		Throttle: throttleConfig{
+			MaxReloads:     throttleMaxReloads,
+			ReloadInterval: throttleReloadInterval,
+			FlapThreshold:  throttleFlapThreshold,
+			FlapWindow:     throttleFlapWindow,
+		},
+		Output: outputConfig{
+			PrometheusConfig: prometheusConfigPath,
+			GCInterval:       fileSDGCInterval,
+		},
+		Credentials: producer.CredentialsConfig{
+			SecretsDir: secretsDir,
+		},
+		Git: gitConfig{
+			Remote:      gitRemote,
+			AuthorName:  gitAuthorName,
+			AuthorEmail: gitAuthorEmail,
+		},
+		Tracing: tracingConfig{
+			SampleRatio: tracingSampleRatio,
+		},
+		LeaderElection: leaderElectionConfig{
+			RetryInterval: leaderRetryInterval,
+			Consul: consulConfig{
+				Address:    consulAddress,
+				Key:        consulKey,
+				SessionTTL: consulSessionTTL,
+			},
+		},
+	}
+}
+
+// LoadConfig reads path over the defaults; an empty path leaves them as they are
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("%v: %s", ErrConfigRead, err)
+	}
+
+	err = yaml.UnmarshalStrict(f, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("%v: %s", ErrConfigParse, err)
+	}
+	return cfg, nil
+}
+
+func (cfg Config) targetHost() string {
+	if cfg.TargetHost != "" {
+		return cfg.TargetHost
+	}
+	if cfg.Mode == sidecarMode {
+		return dockerHostname(cfg.Docker.Host)
+	}
+	return dockerHostAddress
+}
+
+func (cfg Config) outputs() []groupOutput {
+	reload := cfg.Reload
+	outputs := []groupOutput{{output: cfg.Output, reload: &reload}}
+	for _, group := range sortedKeys(cfg.Groups) {
+		gc := cfg.Groups[group]
+		o := groupOutput{
+			group: group,
+			output: outputConfig{
+				PrometheusConfig: gc.PrometheusConfig,
+				BaseConfig:       gc.BaseConfig,
+				FileSDDir:        gc.FileSDDir,
+				GCInterval:       cfg.Output.GCInterval,
+			},
+		}
+		if gc.Reload != nil {
+			reload := *gc.Reload
+			if reload.Timeout == 0 {
+				reload.Timeout = reloadTimeout
+			}
+			o.reload = &reload
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs
+}
+
+func (cfg Config) targetResolver() resolver {
+	if !cfg.Resolver.ValidateTargets {
+		return nil
+	}
+	return newResolver(cfg.Resolver)
+}
+
+// Validate checks every section, returning the first problem found
+func (cfg Config) Validate() error {
+	switch cfg.Mode {
+	case hostMode:
+	case sidecarMode:
+		if cfg.targetHost() == "" {
+			return fmt.Errorf("%v: sidecar mode needs a tcp:// or ssh:// docker host, or an explicit target_host", ErrConfigInvalid)
+		}
+	default:
+		return fmt.Errorf("%v: unknown mode %q", ErrConfigInvalid, cfg.Mode)
+	}
+	switch cfg.AddressFamily {
+	case "", addressFamilyIPv4, addressFamilyIPv6:
+	default:
+		return fmt.Errorf("%v: address_family must be ipv4 or ipv6", ErrConfigInvalid)
+	}
+	if cfg.Output.PrometheusConfig == "" {
+		return fmt.Errorf("%v: output prometheus_config must not be empty", ErrConfigInvalid)
+	}
+
+	for name := range cfg.ExternalLabels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("%v: invalid external label name %q", ErrConfigInvalid, name)
+		}
+	}
+	for _, field := range cfg.TargetLabels {
+		if _, ok := metadataFields[field]; !ok {
+			return fmt.Errorf("%v: unknown target label field %q", ErrConfigInvalid, field)
+		}
+	}
+	err := cfg.Log.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Docker.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Resolver.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Filters.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Identity.Validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Events.Validate()
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(cfg.Credentials.SecretsDir) {
+		return fmt.Errorf("%v: credentials secrets_dir must be an absolute path", ErrConfigInvalid)
+	}
+	err = cfg.validateConsume()
+	if err != nil {
+		return err
+	}
+	if cfg.Reconcile.Interval < 0 {
+		return fmt.Errorf("%v: reconcile interval must not be negative", ErrConfigInvalid)
+	}
+	if cfg.Producers.ScanInterval < 0 {
+		return fmt.Errorf("%v: producers scan_interval must not be negative", ErrConfigInvalid)
+	}
+	err = cfg.Throttle.validate()
+	if err != nil {
+		return err
+	}
+	if cfg.Output.FileSDDir != "" && cfg.Output.GCInterval <= 0 {
+		return fmt.Errorf("%v: output gc_interval must be positive", ErrConfigInvalid)
+	}
+	err = cfg.validateGroups()
+	if err != nil {
+		return err
+	}
+	err = cfg.LeaderElection.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.validateGit()
+	if err != nil {
+		return err
+	}
+	err = cfg.Retry.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Pipeline.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.Tracing.validate()
+	if err != nil {
+		return err
+	}
+	err = cfg.GRPC.TLS.validate()
+	if err != nil {
+		return err
+	}
+	return cfg.Reload.validate()
+}
+
+// validateGroups keeps every output apart; two groups writing the same files would undo each other
+func (cfg Config) validateGroups() error {
+	paths := make(map[string]bool)
+	for _, o := range cfg.outputs() {
+		if o.output.PrometheusConfig == "" {
+			return fmt.Errorf("%v: group %q needs a prometheus_config", ErrConfigInvalid, o.group)
+		}
+		for _, path := range []string{o.output.PrometheusConfig, o.output.FileSDDir} {
+			if path == "" {
+				continue
+			}
+			if paths[filepath.Clean(path)] {
+				return fmt.Errorf("%v: %s is written by more than one output", ErrConfigInvalid, path)
+			}
+			paths[filepath.Clean(path)] = true
+		}
+		if o.output.BaseConfig != "" && filepath.Clean(o.output.BaseConfig) == filepath.Clean(o.output.PrometheusConfig) {
+			return fmt.Errorf("%v: base_config must not be the prometheus_config it renders", ErrConfigInvalid)
+		}
+		// the file_sd job is rendered next to the base jobs; Prometheus rejects a duplicate job_name
+		if o.output.BaseConfig != "" && o.output.FileSDDir != "" {
+			base, err := loadBaseConfig(o.output.BaseConfig)
+			if err != nil {
+				return err
+			}
+			if base.jobs[fileSDJobName] {
+				return fmt.Errorf("%v: base_config %s defines a %s job, which file_sd_dir renders", ErrConfigInvalid, o.output.BaseConfig, fileSDJobName)
+			}
+		}
+
+		if o.group == "" || o.reload == nil {
+			continue
+		}
+		err := o.reload.validate()
+		if err != nil {
+			return fmt.Errorf("%s (group %q)", err, o.group)
+		}
+	}
+	return nil
+}
+
+// validateConsume checks the consume schedule against the scrape interval of every output,
+// which its base config may set
+func (cfg Config) validateConsume() error {
+	for _, o := range cfg.outputs() {
+		scrapeInterval, err := o.output.scrapeInterval()
+		if err != nil {
+			return err
+		}
+		err = cfg.Consume.validate(scrapeInterval)
+		if err != nil && o.group != "" {
+			return fmt.Errorf("%s (group %q)", err, o.group)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg Config) validateGit() error {
+	if cfg.Git.Repo == "" {
+		return nil
+	}
+	if cfg.Git.Push && cfg.Git.Remote == "" {
+		return fmt.Errorf("%v: git remote must not be empty when pushing", ErrConfigInvalid)
+	}
+	for _, o := range cfg.outputs() {
+		for _, path := range []string{o.output.PrometheusConfig, o.output.FileSDDir} {
+			if path != "" && !insideRepo(cfg.Git.Repo, path) {
+				return fmt.Errorf("%v: %s is outside the git repo %s", ErrConfigInvalid, path, cfg.Git.Repo)
+			}
+		}
+	}
+	return nil
+}
+
+func (dc dockerConfig) validate() error {
+	scheme := ""
+	if dc.Host != "" {
+		u, err := url.Parse(dc.Host)
+		if err != nil {
+			return fmt.Errorf("%v: docker host %q: %s", ErrConfigInvalid, dc.Host, err)
+		}
+		scheme = u.Scheme
+		switch scheme {
+		case "unix", "npipe", "tcp", "http", "https", "ssh":
+		default:
+			return fmt.Errorf("%v: docker host %q must be unix://, npipe://, tcp:// or ssh://", ErrConfigInvalid, dc.Host)
+		}
+	}
+
+	if (dc.TLS.CertFile == "") != (dc.TLS.KeyFile == "") {
+		return fmt.Errorf("%v: docker tls cert_file and key_file must be set together", ErrConfigInvalid)
+	}
+	if dc.TLS != (dockerTLSConfig{}) && scheme != "" && scheme != "tcp" && scheme != "https" {
+		return fmt.Errorf("%v: docker tls only applies to tcp:// hosts", ErrConfigInvalid)
+	}
+	if dc.APIVersion != "" && !dockerAPIVersionPattern.MatchString(dc.APIVersion) {
+		return fmt.Errorf("%v: docker api_version %q must look like 1.43", ErrConfigInvalid, dc.APIVersion)
+	}
+	return nil
+}
+
+func (lc leaderElectionConfig) validate() error {
+	switch lc.Backend {
+	case "":
+		return nil
+	case fileLeaderBackend:
+		if lc.LockFile == "" {
+			return fmt.Errorf("%v: leader_election lock_file must not be empty", ErrConfigInvalid)
+		}
+	case consulLeaderBackend:
+		if lc.Consul.Address == "" || lc.Consul.Key == "" {
+			return fmt.Errorf("%v: leader_election consul needs an address and a key", ErrConfigInvalid)
+		}
+		// Consul only accepts session TTLs between 10s and 24h
+		if lc.Consul.SessionTTL < 10*time.Second || lc.Consul.SessionTTL > 24*time.Hour {
+			return fmt.Errorf("%v: leader_election consul session_ttl must be between 10s and 24h", ErrConfigInvalid)
+		}
+	default:
+		return fmt.Errorf("%v: unknown leader_election backend %q", ErrConfigInvalid, lc.Backend)
+	}
+	if lc.RetryInterval <= 0 {
+		return fmt.Errorf("%v: leader_election retry_interval must be positive", ErrConfigInvalid)
+	}
+	return nil
+}
+
+func (rc resolverConfig) validate() error {
+	servers := append([]string{}, rc.Servers...)
+	for domain, domainServers := range rc.Domains {
+		if len(domainServers) == 0 {
+			return fmt.Errorf("%v: resolver domain %q has no servers", ErrConfigInvalid, domain)
+		}
+		servers = append(servers, domainServers...)
+	}
+
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			return fmt.Errorf("%v: resolver server %q must be host:port", ErrConfigInvalid, server)
+		}
+	}
+	return nil
+}
+
+func (pc pipelineConfig) validate() error {
+	for _, name := range pc.Events {
+		if _, ok := eventProcessors[name]; !ok {
+			return fmt.Errorf("%v: unknown event processor %q", ErrConfigInvalid, name)
+		}
+	}
+	for _, name := range pc.Targets {
+		if _, ok := targetProcessors[name]; !ok {
+			return fmt.Errorf("%v: unknown target processor %q", ErrConfigInvalid, name)
+		}
+	}
+	return nil
+}
+
+func (tc throttleConfig) validate() error {
+	if tc.MaxReloads < 0 || tc.FlapThreshold < 0 {
+		return fmt.Errorf("%v: throttle max_reloads and flap_threshold must not be negative", ErrConfigInvalid)
+	}
+	if tc.MaxReloads > 0 && tc.ReloadInterval <= 0 {
+		return fmt.Errorf("%v: throttle reload_interval must be positive", ErrConfigInvalid)
+	}
+	if tc.FlapThreshold > 0 && tc.FlapWindow <= 0 {
+		return fmt.Errorf("%v: throttle flap_window must be positive", ErrConfigInvalid)
+	}
+	return nil
+}
+
+func (tc grpcTLSConfig) validate() error {
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return fmt.Errorf("%v: grpc tls needs both cert_file and key_file", ErrConfigInvalid)
+	}
+	if tc.ClientCAFile != "" && tc.CertFile == "" {
+		return fmt.Errorf("%v: grpc tls client_ca_file needs a cert_file", ErrConfigInvalid)
+	}
+	return nil
+}
+
+func (tc tracingConfig) validate() error {
+	if strings.Contains(tc.Endpoint, "://") {
+		return fmt.Errorf("%v: tracing endpoint must be host:port, without a scheme", ErrConfigInvalid)
+	}
+	if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+		return fmt.Errorf("%v: tracing sample_ratio must be between 0 and 1", ErrConfigInvalid)
+	}
+	return nil
+}
+
+// consuming more often than Prometheus scrapes only adds reload churn
+func (cc consumeConfig) validate(scrapeInterval time.Duration) error {
+	if cc.Interval < scrapeInterval {
+		return fmt.Errorf("%v: consume interval %s is shorter than the global scrape interval %s", ErrConfigInvalid, cc.Interval, scrapeInterval)
+	}
+	if cc.Jitter < 0 || cc.Jitter >= cc.Interval {
+		return fmt.Errorf("%v: consume jitter must be between 0 and the consume interval", ErrConfigInvalid)
+	}
+	if cc.Debounce < 0 {
+		return fmt.Errorf("%v: consume debounce must not be negative", ErrConfigInvalid)
+	}
+
+	if a := cc.Adaptive; a.Enabled {
+		if a.Min < scrapeInterval {
+			return fmt.Errorf("%v: adaptive min %s is shorter than the global scrape interval %s", ErrConfigInvalid, a.Min, scrapeInterval)
+		}
+		if cc.Interval < a.Min || cc.Interval > a.Max {
+			return fmt.Errorf("%v: consume interval must lie between adaptive min and max", ErrConfigInvalid)
+		}
+		if a.BusyEvents < 1 {
+			return fmt.Errorf("%v: adaptive busy_events must be at least 1", ErrConfigInvalid)
+		}
+	}
+	return nil
+}
+
+func (rc retryConfig) validate() error {
+	if rc.Attempts < 1 {
+		return fmt.Errorf("%v: retry attempts must be at least 1", ErrConfigInvalid)
+	}
+	if rc.InitialBackoff <= 0 || rc.MaxBackoff < rc.InitialBackoff {
+		return fmt.Errorf("%v: retry backoff must be positive and max_backoff >= initial_backoff", ErrConfigInvalid)
+	}
+	return nil
+}
+
+// an empty endpoint leaves reloading to someone else, e.g. config management syncing a git repo
+func (rc reloadConfig) validate() error {
+	if rc.Endpoint == "" {
+		return nil
+	}
+	if rc.Timeout <= 0 {
+		return fmt.Errorf("%v: reload timeout must be positive", ErrConfigInvalid)
+	}
+
+	ba := rc.BasicAuth
+	if ba.Password != "" && ba.PasswordFile != "" {
+		return fmt.Errorf("%v: only one of password and password_file may be set", ErrConfigInvalid)
+	}
+	if ba.Username == "" && (ba.Password != "" || ba.PasswordFile != "") {
+		return fmt.Errorf("%v: basic auth password set without username", ErrConfigInvalid)
+	}
+	if rc.BearerToken != "" && rc.BearerTokenFile != "" {
+		return fmt.Errorf("%v: only one of bearer_token and bearer_token_file may be set", ErrConfigInvalid)
+	}
+	if ba.Username != "" && (rc.BearerToken != "" || rc.BearerTokenFile != "") {
+		return fmt.Errorf("%v: basic auth and bearer token are mutually exclusive", ErrConfigInvalid)
+	}
+	return rc.TLS.validate()
+}
+
+func (tc tlsConfig) validate() error {
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return fmt.Errorf("%v: cert_file and key_file must be set together", ErrConfigInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,850 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+var (
+	ErrConsumerInspectContainer = fmt.Errorf("consumer inspecting container")
+	ErrConsumerGetCurrentState  = fmt.Errorf("consumer getting current state")
+	ErrConsumerParseHostMapping = fmt.Errorf("consumer parsing host mapping")
+	ErrConsumerDiffTargets      = fmt.Errorf("consumer diffing targets")
+	ErrConsumerPublish          = fmt.Errorf("consumer publishing scrape targets")
+	ErrConsumerInvalidConfig    = fmt.Errorf("consumer validating rendered config")
+	ErrConsumerSendSignal       = fmt.Errorf("consumer sending signal")
+	ErrConsumerBackup           = fmt.Errorf("consumer backing up prometheus config")
+	ErrConsumerRollback         = fmt.Errorf("consumer rolling back prometheus config")
+)
+
+const (
+	prometheusConfigPath = "prometheus-local/prometheus.yaml"
+	dockerHostAddress    = "host.docker.internal"
+	managedLabel         = "__meta_target_explorer_managed"
+
+	globalScrapeInterval = "60s"
+
+	backupSuffix = ".bak"
+
+	rejectedInitialBackoff = time.Minute
+	rejectedMaxBackoff     = 30 * time.Minute
+)
+
+type consumer struct {
+	logger      *logrus.Logger
+	docker      producer.Docker
+	sinks       []*sink
+	health      *health
+	retrier     retrier
+	pipeline    pipeline
+	namer       producer.JobNamer
+	filter      producer.Filter
+	conventions producer.Conventions
+	policy      producer.Policy
+	schedule    consumeConfig
+	interval    time.Duration
+
+	reconcileInterval time.Duration
+	gcInterval        time.Duration
+	host              string
+	addressFamily     string
+	dryRun            bool
+	elector           elector
+	leading           bool
+	git               *gitPublisher
+
+	// pending holds a target set whose publish or reload ultimately failed, or is still throttled
+	pending    map[string]target
+	rejected   *rejection
+	published  map[string]target
+	quarantine map[string]quarantined
+	owners     map[string]string
+	changes    []change
+	audit      auditLog
+	store      *store
+	resolver   resolver
+	debug      *debugState
+	control    control
+	exclusions map[string]time.Time
+	flaps      *flapDetector
+}
+
+func newConsumer(logger *logrus.Logger, docker producer.Docker, sinks []*sink, h *health, st *store, dbg *debugState, cfg Config) *consumer {
+	conventions := producer.NewConventions(cfg.Compat, cfg.Credentials)
+	return &consumer{
+		logger:      logger,
+		docker:      docker,
+		sinks:       sinks,
+		health:      h,
+		retrier:     newRetrier(logger, cfg.Retry),
+		pipeline:    newPipeline(cfg),
+		namer:       producer.NewJobNamer(cfg.Identity),
+		filter:      producer.NewFilter(cfg.Filters, conventions),
+		conventions: conventions,
+		policy:      producer.NewPolicy(cfg.Events),
+		schedule:    cfg.Consume,
+		interval:    cfg.Consume.Interval,
+
+		reconcileInterval: cfg.Reconcile.Interval,
+		gcInterval:        cfg.Output.GCInterval,
+		host:              cfg.targetHost(),
+		addressFamily:     cfg.AddressFamily,
+		elector:           newElector(logger, cfg.LeaderElection),
+		git:               newGitPublisher(logger, cfg),
+		dryRun:            cfg.DryRun,
+		quarantine:        make(map[string]quarantined),
+		owners:            make(map[string]string),
+		audit:             newAuditLog(cfg.Audit.Path),
+		store:             st,
+		resolver:          cfg.targetResolver(),
+		debug:             dbg,
+		control:           newControl(),
+		exclusions:        make(map[string]time.Time),
+		flaps:             newFlapDetector(logger, cfg.Throttle),
+	}
+}
+
+func (c *consumer) run(ctx context.Context, el *eventlog.Log) {
+	var leadership chan bool
+	if c.elector == nil {
+		c.leading = true
+		leader.Set(1)
+	} else {
+		c.logger.Info("standing by until elected leader")
+		leadership = make(chan bool, 1)
+		go c.elector.run(ctx, leadership)
+	}
+
+	c.restore(ctx)
+
+	tick := time.After(c.nextInterval())
+	gc, reconcile := c.tickers()
+	defer func() {
+		gc.stop()
+		reconcile.stop()
+	}()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-c.control.reload:
+			c.reload(ctx, req)
+			gc.stop()
+			reconcile.stop()
+			gc, reconcile = c.tickers()
+			tick = time.After(c.nextInterval())
+		case <-reconcile.c():
+			c.expireExclusions()
+			c.reconcile(ctx)
+		case <-c.control.reconcile:
+			c.consume(ctx, el)
+			c.reconcile(ctx)
+		case ex := <-c.control.exclude:
+			c.exclude(ctx, ex)
+		case leading := <-leadership:
+			c.lead(ctx, leading)
+		case <-gc.c():
+			c.collectGarbage()
+		case <-el.Notify():
+			debounce = time.After(c.schedule.Debounce)
+		case <-debounce:
+			debounce = nil
+			c.adapt(el.Len())
+			c.consume(ctx, el)
+		case <-tick:
+			c.adapt(el.Len())
+			c.consume(ctx, el)
+			if c.expireExclusions() {
+				c.reconcile(ctx)
+			}
+			tick = time.After(c.nextInterval())
+		}
+	}
+}
+
+// ticker is a time.Ticker that never fires for a non-positive interval
+type ticker struct {
+	*time.Ticker
+}
+
+func newTicker(interval time.Duration) ticker {
+	if interval <= 0 {
+		return ticker{}
+	}
+	return ticker{time.NewTicker(interval)}
+}
+
+func (t ticker) c() <-chan time.Time {
+	if t.Ticker == nil {
+		return nil
+	}
+	return t.C
+}
+
+func (t ticker) stop() {
+	if t.Ticker != nil {
+		t.Stop()
+	}
+}
+
+// tickers are the file_sd garbage collection and the periodic reconcile, as configured
+func (c *consumer) tickers() (ticker, ticker) {
+	gc := newTicker(0)
+	if c.fileSDEnabled() {
+		gc = newTicker(c.gcInterval)
+	}
+	return gc, newTicker(c.reconcileInterval)
+}
+
+func (c *consumer) collectGarbage() {
+	// files of a target set Prometheus hasn't loaded yet aren't in published
+	if c.published == nil || c.pending != nil || c.dryRun || !c.leading {
+		return
+	}
+
+	for _, s := range c.sinks {
+		if !s.fileSD.enabled() {
+			continue
+		}
+		err := s.fileSD.collect(s.targets(c.published))
+		if err != nil {
+			c.logger.Error(err)
+		}
+	}
+}
+
+func (c *consumer) fileSDEnabled() bool {
+	for _, s := range c.sinks {
+		if s.fileSD.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *consumer) nextInterval() time.Duration {
+	if c.schedule.Jitter <= 0 {
+		return c.interval
+	}
+	return c.interval + time.Duration(rand.Int63n(int64(c.schedule.Jitter)))
+}
+
+// adapt halves the interval when a batch is busy and doubles it when idle, within the configured bounds
+func (c *consumer) adapt(batch int) {
+	adaptive := c.schedule.Adaptive
+	if !adaptive.Enabled {
+		return
+	}
+
+	next := c.interval
+	switch {
+	case batch >= adaptive.BusyEvents:
+		next /= 2
+		if next < adaptive.Min {
+			next = adaptive.Min
+		}
+	case batch == 0:
+		next *= 2
+		if next > adaptive.Max {
+			next = adaptive.Max
+		}
+	}
+
+	if next != c.interval {
+		c.logger.Debugf("adjusted consume interval from %s to %s after a batch of %d events", c.interval, next, batch)
+		c.interval = next
+	}
+}
+
+// consume only counts towards readiness once the state was read and committed; a tick with
+// nothing to do counts if the state is readable
+func (c *consumer) consume(ctx context.Context, el *eventlog.Log) {
+	events := el.Flush()
+	now := time.Now()
+	if len(events) == 0 && c.pending == nil && !c.flaps.due(now) {
+		if _, err := c.state(); err == nil {
+			c.health.consumed()
+		}
+		return
+	}
+
+	// each cycle is a trace of its own
+	ctx, span := tracer.Start(ctx, "consume", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.Int("target_explorer.events", len(events)),
+		attribute.Int64("target_explorer.events.waited_ms", eventsWaited(events, now).Milliseconds()),
+		attribute.Bool("target_explorer.pending", c.pending != nil),
+	))
+
+	consumeCycles.Inc()
+	c.debug.recordEvents(events)
+	filteredEvents := c.pipeline.processEvents(c.flaps.coalesce(events, now))
+
+	stateMap, err := c.state()
+	if err != nil {
+		c.logger.Errorf("%v: %s", ErrConsumerGetCurrentState, err)
+		endSpan(span, err)
+		return
+	}
+
+	scrapeTargets := c.diff(ctx, filteredEvents, stateMap)
+	err = c.commit(ctx, scrapeTargets)
+	endSpan(span, err)
+	if err == nil {
+		c.health.consumed()
+	}
+}
+
+func (c *consumer) commit(ctx context.Context, scrapeTargets map[string]target) error {
+	isolated, err := c.isolate(ctx, scrapeTargets)
+	if err != nil {
+		c.requeue(scrapeTargets)
+		return err
+	}
+	scrapeTargets = isolated
+	c.debug.recordDesired(scrapeTargets, c.owners, c.quarantine)
+
+	// isolate already set aside invalid targets, so whatever fails here is not specific to a job
+	promConfs := make([]prometheusConf, len(c.sinks))
+	for i, s := range c.sinks {
+		promConfs[i] = s.render(scrapeTargets)
+		if problems := validatePrometheusConf(promConfs[i]); len(problems) > 0 {
+			msgs := make([]string, 0, len(problems))
+			for _, problem := range problems {
+				msgs = append(msgs, problem.Error())
+			}
+			err := fmt.Errorf("%v: %s: %s", ErrConsumerInvalidConfig, s.output.PrometheusConfig, strings.Join(msgs, "; "))
+			c.logger.Error(err)
+			invalidConfigs.Inc()
+			c.requeue(scrapeTargets)
+			return err
+		}
+	}
+
+	if c.dryRun {
+		c.dryRunCommit(scrapeTargets)
+		return nil
+	}
+	if !c.leading {
+		c.standby(scrapeTargets)
+		return nil
+	}
+	if c.rejected.holds(scrapeTargets, time.Now()) {
+		c.logger.Debugf("holding back %d scrape targets Prometheus failed to reload with until %s", len(scrapeTargets), c.rejected.retryAt.Format(time.RFC3339))
+		c.pending = scrapeTargets
+		return nil
+	}
+
+	_, span := tracer.Start(ctx, "publish", trace.WithAttributes(attribute.Int("target_explorer.targets", len(scrapeTargets))))
+	err = c.retrier.do(ctx, "publish", func() error {
+		return c.publish(scrapeTargets, promConfs)
+	})
+	endSpan(span, err)
+	if err != nil {
+		err = fmt.Errorf("%v: %s", ErrConsumerPublish, err)
+		c.logger.Error(err)
+		publishFailures.Inc()
+		c.requeue(scrapeTargets)
+		return err
+	}
+
+	// each Prometheus is reloaded on its own, so one rejecting its config doesn't hold back the others
+	var reloadErr error
+	throttled := false
+	for _, s := range c.sinks {
+		if s.reloader == nil || !s.reloadNeeded {
+			continue
+		}
+		output := attribute.String("target_explorer.output", s.output.PrometheusConfig)
+		if !s.limiter.allow(time.Now()) {
+			s.log().Warnf("reload rate limit of %d per %s reached, deferring the reload", s.limiter.max, s.limiter.interval)
+			reloadsThrottled.Inc()
+			trace.SpanFromContext(ctx).AddEvent("reload throttled", trace.WithAttributes(output))
+			throttled = true
+			continue
+		}
+
+		_, span := tracer.Start(ctx, "reload", trace.WithAttributes(output))
+		err = c.retrier.do(ctx, "reload", s.sendSignal)
+		endSpan(span, err)
+		if err != nil {
+			reloadErr = fmt.Errorf("%v: %s", ErrConsumerSendSignal, err)
+			s.log().Error(reloadErr)
+			s.rollback(ctx, c.retrier)
+			continue
+		}
+		s.reloadNeeded = false
+	}
+	if reloadErr != nil {
+		c.rejected = c.rejected.next(scrapeTargets, time.Now())
+		c.logger.Warnf("not writing the same scrape targets again for %s unless they change", c.rejected.backoff)
+		c.requeue(scrapeTargets)
+		return reloadErr
+	}
+	if throttled {
+		// the config is written already; re-queueing makes a later cycle send the reload
+		c.requeue(scrapeTargets)
+		return nil
+	}
+	c.pending = nil
+	c.rejected = nil
+
+	// only what Prometheus loaded is recorded, audited and stored; the changes of cycles whose
+	// reload failed or was throttled are still in c.changes
+	c.published = scrapeTargets
+	publishedTargets.Set(float64(len(scrapeTargets)))
+	c.debug.recordPublished(scrapeTargets, c.owners, c.changes)
+	changes := c.changes
+	c.flushChanges()
+
+	var storeErr error
+	if c.store != nil {
+		storeErr = c.store.save(scrapeTargets, c.owners)
+		if storeErr != nil {
+			c.logger.Error(storeErr)
+		}
+	}
+
+	// a failed commit is picked up by the next one
+	if c.git != nil {
+		err = c.git.publish(ctx, c.retrier, changes)
+		if err != nil {
+			c.logger.Error(err)
+			return err
+		}
+	}
+	return storeErr
+}
+
+func (c *consumer) requeue(scrapeTargets map[string]target) {
+	c.logger.Warnf("re-queued %d scrape targets for the next consume cycle", len(scrapeTargets))
+	c.pending = scrapeTargets
+}
+
+// rejection is a target set some Prometheus failed to reload with; the same set isn't written
+// again until its backoff lapses, doubling with every further failure
+type rejection struct {
+	targets map[string]target
+	retryAt time.Time
+	backoff time.Duration
+}
+
+func (r *rejection) holds(scrapeTargets map[string]target, now time.Time) bool {
+	return r != nil && now.Before(r.retryAt) && sameTargets(r.targets, scrapeTargets)
+}
+
+func (r *rejection) next(scrapeTargets map[string]target, now time.Time) *rejection {
+	backoff := rejectedInitialBackoff
+	if r != nil && sameTargets(r.targets, scrapeTargets) {
+		backoff = r.backoff * 2
+		if backoff > rejectedMaxBackoff {
+			backoff = rejectedMaxBackoff
+		}
+	}
+	return &rejection{copyTargets(scrapeTargets), now.Add(backoff), backoff}
+}
+
+func sameTargets(a, b map[string]target) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for job, t := range a {
+		if o, ok := b[job]; !ok || !t.equal(o) {
+			return false
+		}
+	}
+	return true
+}
+
+type prometheusConf struct {
+	Global struct {
+		ScrapeInterval string `yaml:"scrape_interval"`
+	} `yaml:"global"`
+	ScrapeConfigs []scrapeConfig `yaml:"scrape_configs"`
+
+	base *baseConfig
+	// discovered are the jobs finding their targets through a service discovery the agent
+	// doesn't model, such as kubernetes_sd_configs
+	discovered map[string]bool
+}
+
+type scrapeConfig struct {
+	JobName       string               `yaml:"job_name"`
+	MetricsPath   string               `yaml:"metrics_path,omitempty"`
+	Scheme        string               `yaml:"scheme,omitempty"`
+	TLSConfig     *scrapeTLSConfig     `yaml:"tls_config,omitempty"`
+	BasicAuth     *scrapeBasicAuth     `yaml:"basic_auth,omitempty"`
+	Authorization *scrapeAuthorization `yaml:"authorization,omitempty"`
+	StaticConfigs []staticConfig       `yaml:"static_configs,omitempty"`
+	FileSDConfigs []fileSDConfig       `yaml:"file_sd_configs,omitempty"`
+}
+
+type scrapeTLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+type scrapeBasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type scrapeAuthorization struct {
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+type staticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+type target struct {
+	address string
+	labels  map[string]string
+	managed bool
+	tls     producer.ScrapeTLS
+	auth    producer.ScrapeAuth
+	group   string
+}
+
+func newScrapeTLS(sc scrapeConfig) producer.ScrapeTLS {
+	if sc.Scheme != "https" {
+		return producer.ScrapeTLS{}
+	}
+	tls := producer.ScrapeTLS{Enabled: true}
+	if sc.TLSConfig != nil {
+		tls.CAFile = sc.TLSConfig.CAFile
+		tls.InsecureSkipVerify = sc.TLSConfig.InsecureSkipVerify
+	}
+	return tls
+}
+
+// withTLS sets the scheme and tls_config of the job's scrape_config
+func withTLS(sc scrapeConfig, tls producer.ScrapeTLS) scrapeConfig {
+	if !tls.Enabled {
+		return sc
+	}
+	sc.Scheme = "https"
+	if tls.CAFile != "" || tls.InsecureSkipVerify {
+		sc.TLSConfig = &scrapeTLSConfig{CAFile: tls.CAFile, InsecureSkipVerify: tls.InsecureSkipVerify}
+	}
+	return sc
+}
+
+func newScrapeAuth(sc scrapeConfig) producer.ScrapeAuth {
+	var auth producer.ScrapeAuth
+	if sc.BasicAuth != nil {
+		auth.Username = sc.BasicAuth.Username
+		auth.PasswordFile = sc.BasicAuth.PasswordFile
+	}
+	if sc.Authorization != nil {
+		auth.TokenFile = sc.Authorization.CredentialsFile
+	}
+	return auth
+}
+
+func withAuth(sc scrapeConfig, auth producer.ScrapeAuth) scrapeConfig {
+	if auth.PasswordFile != "" {
+		sc.BasicAuth = &scrapeBasicAuth{Username: auth.Username, PasswordFile: auth.PasswordFile}
+	}
+	if auth.TokenFile != "" {
+		sc.Authorization = &scrapeAuthorization{CredentialsFile: auth.TokenFile}
+	}
+	return sc
+}
+
+// ownScrapeConfig reports whether the target needs settings that only a scrape_config of its own can carry
+func (t target) ownScrapeConfig() bool {
+	return t.tls.Enabled || t.auth != (producer.ScrapeAuth{})
+}
+
+func (t target) equal(o target) bool {
+	if t.address != o.address || t.managed != o.managed || t.tls != o.tls || t.auth != o.auth || t.group != o.group || len(t.labels) != len(o.labels) {
+		return false
+	}
+	for name, value := range t.labels {
+		if o.labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (t target) withLabels(labels map[string]string) target {
+	if len(labels) == 0 {
+		return t
+	}
+
+	merged := make(map[string]string, len(t.labels)+len(labels))
+	for name, value := range t.labels {
+		merged[name] = value
+	}
+	for name, value := range labels {
+		merged[name] = value
+	}
+	t.labels = merged
+	return t
+}
+
+func (c *consumer) state() (map[string]target, error) {
+	if c.pending != nil {
+		return c.pending, nil
+	}
+	// nothing is ever written in dry-run mode or by a standby, so what would have been published is the state
+	if (c.dryRun || !c.leading) && c.published != nil {
+		return copyTargets(c.published), nil
+	}
+	return c.getCurrentState()
+}
+
+func (c *consumer) getCurrentState() (map[string]target, error) {
+	stateMap := make(map[string]target, 0)
+	for _, s := range c.sinks {
+		targets, err := s.state()
+		if err != nil {
+			return nil, err
+		}
+		for job, t := range targets {
+			stateMap[job] = t
+		}
+	}
+	return stateMap, nil
+}
+
+func (c *consumer) diff(ctx context.Context, events []eventlog.Event, stateMap map[string]target) map[string]target {
+	ctx, span := tracer.Start(ctx, "diff")
+	defer span.End()
+
+	changes := len(c.changes)
+	for _, event := range events {
+		log := c.logger.WithFields(eventFields(event))
+		span.AddEvent("event", trace.WithAttributes(
+			actionAttribute.String(event.Action.String()),
+			jobAttribute.String(event.Name),
+			semconv.ContainerID(event.ContainerID),
+		))
+
+		if !event.Up {
+			c.remove(event, stateMap, log)
+			continue
+		}
+		if c.excluded(event.Name) {
+			log.Debug("ignoring event, job is excluded")
+			continue
+		}
+
+		target, err := c.lookupTargetFor(ctx, event.ContainerID, event.Name)
+		if err == producer.ErrFiltered {
+			log.Debug("ignoring event, container is excluded by filters")
+			continue
+		}
+		if err == producer.ErrSuspended {
+			log.Debug("container is paused or unhealthy, treating the event as a removal")
+			c.remove(event, stateMap, log)
+			continue
+		}
+		if err != nil {
+			log.Errorf("%v: %s", ErrConsumerDiffTargets, err)
+			continue
+		}
+
+		current, ok := stateMap[event.Name]
+		switch {
+		case !ok:
+			log.Infof("adding target %s", target.address)
+			c.track(changeAdd, event.Name, target, event.ContainerID, event.Action.String())
+		case current.address != target.address:
+			log.Infof("updating target %s, host port changed from %s", target.address, current.address)
+			c.track(changeUpdate, event.Name, target, event.ContainerID, event.Action.String())
+		case !current.equal(target):
+			log.Infof("updating target %s", target.address)
+			c.track(changeUpdate, event.Name, target, event.ContainerID, event.Action.String())
+		}
+		c.own(event.Name, event.ContainerID)
+		stateMap[event.Name] = target
+	}
+	diffSize.Observe(float64(len(c.changes) - changes))
+	span.SetAttributes(attribute.Int("target_explorer.changes", len(c.changes)-changes))
+	return stateMap
+}
+
+func (c *consumer) remove(event eventlog.Event, stateMap map[string]target, log *logrus.Entry) {
+	job, ok := c.ownedBy(event.ContainerID, event.Name)
+	if !ok {
+		return
+	}
+	if current, ok := stateMap[job]; ok {
+		log.WithField("job", job).Info("removing target")
+		c.track(changeRemove, job, current, event.ContainerID, event.Action.String())
+	}
+	delete(stateMap, job)
+	delete(c.owners, job)
+	forgetRuntimeStats(event.ContainerID)
+}
+
+// own records which container backs a job; a recreated container taking over a job
+// (same name, new ID) is an update of that job rather than a remove/add pair
+func (c *consumer) own(job, containerID string) {
+	if previous, ok := c.owners[job]; ok && previous != containerID {
+		c.logger.WithFields(logrus.Fields{"job": job, "container_id": containerID}).
+			Infof("job moved from container %.12s", previous)
+		forgetRuntimeStats(previous)
+	}
+	c.owners[job] = containerID
+}
+
+// ownedBy finds the job a stopped container backs; a container that has been superseded by
+// a newer one with the same job owns nothing, and jobs of unknown ownership fall back to the event name
+func (c *consumer) ownedBy(containerID, name string) (string, bool) {
+	for job, owner := range c.owners {
+		if owner == containerID {
+			return job, true
+		}
+	}
+
+	if owner, ok := c.owners[name]; ok {
+		c.logger.WithFields(logrus.Fields{"job": name, "container_id": containerID}).
+			Debugf("ignoring stop, job is owned by container %.12s", owner)
+		return "", false
+	}
+	return name, name != ""
+}
+
+func (c *consumer) lookupTargetFor(ctx context.Context, container, job string) (target, error) {
+	ctx, span := tracer.Start(ctx, "inspect", trace.WithAttributes(semconv.ContainerID(container), jobAttribute.String(job)))
+	defer span.End()
+
+	t, err := c.inspectTarget(ctx, container)
+	switch err {
+	case nil:
+		span.SetAttributes(attribute.String("target_explorer.target", t.address))
+	case producer.ErrFiltered, producer.ErrSuspended:
+		// containers left out on purpose are not failures
+		span.SetAttributes(attribute.String("target_explorer.skipped", err.Error()))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return t, err
+}
+
+func (c *consumer) inspectTarget(ctx context.Context, container string) (target, error) {
+	ctx, timeout := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer timeout()
+
+	inspect, err := c.docker.ContainerInspect(ctx, container)
+	if err != nil {
+		producer.DockerErrors.WithLabelValues("container_inspect").Inc()
+		return target{}, fmt.Errorf("%v: %s", ErrConsumerInspectContainer, err)
+	}
+	if !c.filter.Selects(producer.InspectSubject(inspect)) {
+		return target{}, producer.ErrFiltered
+	}
+	if c.policy.Suspended(inspect.State) {
+		return target{}, producer.ErrSuspended
+	}
+
+	var labels map[string]string
+	if inspect.Config != nil {
+		labels = inspect.Config.Labels
+	}
+	port, err := c.conventions.MetricsPort(labels)
+	if err != nil {
+		return target{}, fmt.Errorf("%v: %s", ErrConsumerParseHostMapping, err)
+	}
+	path, err := c.conventions.MetricsPath(labels)
+	if err != nil {
+		return target{}, fmt.Errorf("%v: %s", ErrConsumerParseHostMapping, err)
+	}
+	tls, err := c.conventions.ScrapeTLS(labels)
+	if err != nil {
+		return target{}, fmt.Errorf("%v: %s", ErrConsumerParseHostMapping, err)
+	}
+	auth, err := c.conventions.ScrapeAuth(labels)
+	if err != nil {
+		return target{}, fmt.Errorf("%v: %s", ErrConsumerParseHostMapping, err)
+	}
+
+	hostMapping, ok := inspect.NetworkSettings.Ports[nat.Port(port)]
+	if !ok || len(hostMapping) == 0 {
+		return target{}, fmt.Errorf("%v: port %s not published", ErrConsumerParseHostMapping, port)
+	}
+
+	group := labels[producer.GroupLabel]
+	if c.sink(group) == nil {
+		return target{}, fmt.Errorf("%v: %q", ErrOutputUnknownGroup, group)
+	}
+
+	binding := hostBinding(hostMapping, c.addressFamily)
+	host := c.host
+	if h := bindingHost(binding); h != "" {
+		host = h
+	}
+	if h := labels[producer.HostLabel]; h != "" {
+		host = h
+	}
+
+	t := target{
+		address: targetAddress(host, binding.HostPort),
+		managed: true,
+		tls:     tls,
+		auth:    auth,
+		group:   group,
+	}
+	if path != "" {
+		t = t.withLabels(map[string]string{producer.MetricsPathLabel: path})
+	}
+	return c.pipeline.processTarget(t, inspect), nil
+}
+
+func (c *consumer) publish(scrapeTargets map[string]target, promConfs []prometheusConf) error {
+	// sinks are written independently; a failing one is reported without skipping the rest
+	errs := make([]string, 0)
+
+	for i, s := range c.sinks {
+		if s.fileSD.enabled() {
+			routed := s.targets(scrapeTargets)
+			failed, err := s.fileSD.write(routed)
+			for job, err := range failed {
+				c.quarantineJob(job, routed[job], err)
+			}
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+
+		err := s.writeConfig(promConfs[i])
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v: %s", ErrConsumerPublish, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func sortedJobs(scrapeTargets map[string]target) []string {
+	jobs := make([]string, 0, len(scrapeTargets))
+	for job := range scrapeTargets {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	return jobs
+}
@@ -0,0 +1,211 @@
+package publisher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/sirupsen/logrus"
+
+	"gopkg.in/yaml.v2"
+
+	"github/rolandvarga/target-explorer/pkg/dockerfake"
+)
+
+var web = dockerfake.Container{
+	ID:     "4f2a",
+	Name:   "web",
+	Image:  "nginx",
+	Labels: map[string]string{"scrape_target": "true"},
+	Ports:  nat.PortMap{"2112/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}}},
+}
+
+// step acts on the engine, waits for the events it streams and consumes them; want is the
+// address rendered for each job afterwards
+type step struct {
+	act    func(*dockerfake.Engine) error
+	events int
+	want   map[string]string
+}
+
+func start(c dockerfake.Container) func(*dockerfake.Engine) error {
+	return func(e *dockerfake.Engine) error {
+		e.Start(c)
+		return nil
+	}
+}
+
+func TestConsume(t *testing.T) {
+	moved := web
+	moved.Ports = nat.PortMap{"2112/tcp": {{HostIP: "0.0.0.0", HostPort: "32769"}}}
+	unlabeled := web
+	unlabeled.Labels = nil
+
+	running := map[string]string{"web": "host.docker.internal:32768"}
+	none := map[string]string{}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name:  "start adds the target",
+			steps: []step{{start(web), 1, running}},
+		},
+		{
+			name: "stop removes the target",
+			steps: []step{
+				{start(web), 1, running},
+				{func(e *dockerfake.Engine) error { return e.Stop(web.ID) }, 2, none},
+			},
+		},
+		{
+			name: "die removes the target",
+			steps: []step{
+				{start(web), 1, running},
+				{func(e *dockerfake.Engine) error { return e.Emit(web.ID, "die") }, 1, none},
+			},
+		},
+		{
+			name: "pause removes the target until unpaused",
+			steps: []step{
+				{start(web), 1, running},
+				{func(e *dockerfake.Engine) error { return e.Pause(web.ID) }, 1, none},
+				{func(e *dockerfake.Engine) error { return e.Unpause(web.ID) }, 1, running},
+			},
+		},
+		{
+			name: "unhealthy removes the target until healthy",
+			steps: []step{
+				{start(web), 1, running},
+				{func(e *dockerfake.Engine) error { return e.SetHealth(web.ID, "unhealthy") }, 1, none},
+				{func(e *dockerfake.Engine) error { return e.SetHealth(web.ID, "healthy") }, 1, running},
+			},
+		},
+		{
+			name: "restart on another host port updates the target",
+			steps: []step{
+				{start(web), 1, running},
+				{func(e *dockerfake.Engine) error { return e.Stop(web.ID) }, 2, none},
+				{start(moved), 1, map[string]string{"web": "host.docker.internal:32769"}},
+			},
+		},
+		{
+			name:  "unlabeled containers are left out",
+			steps: []step{{start(unlabeled), 0, none}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := dockerfake.New()
+			a, path := newTestAgent(t, engine)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				a.producers.Run(ctx, a.events)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+			waitFor(t, "the event stream", func() bool {
+				a.health.mu.Lock()
+				defer a.health.mu.Unlock()
+				return a.health.streamConnected
+			})
+
+			for i, s := range tt.steps {
+				err := s.act(engine)
+				if err != nil {
+					t.Fatalf("step %d: %s", i, err)
+				}
+				waitFor(t, "the step's events", func() bool { return a.events.Len() >= s.events })
+
+				a.consumer.consume(ctx, a.events)
+
+				got := renderedTargets(t, path)
+				if len(got) != len(s.want) {
+					t.Fatalf("step %d: rendered %v, want %v", i, got, s.want)
+				}
+				for job, address := range s.want {
+					if got[job] != address {
+						t.Errorf("step %d: job %s rendered at %q, want %q", i, job, got[job], address)
+					}
+				}
+			}
+		})
+	}
+}
+
+func newTestAgent(t *testing.T, engine *dockerfake.Engine) (*Agent, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "prometheus.yaml")
+	cfg := DefaultConfig()
+	cfg.ListenAddress = ""
+	cfg.Reload.Endpoint = ""
+	cfg.Output.PrometheusConfig = path
+	cfg.Producers.ScanInterval = 0
+	err := cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	a, err := NewAgentWith(logger, cfg, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Close)
+	a.consumer.leading = true
+	return a, path
+}
+
+// renderedTargets maps each job of the prometheus.yaml at path to its address; every
+// job must carry the managed label
+func renderedTargets(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	targets := make(map[string]string)
+	f, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return targets
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conf prometheusConf
+	err = yaml.Unmarshal(f, &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sc := range conf.ScrapeConfigs {
+		static := sc.StaticConfigs[0]
+		if static.Labels[managedLabel] != "true" {
+			t.Errorf("job %s rendered without %s", sc.JobName, managedLabel)
+		}
+		targets[sc.JobName] = static.Targets[0]
+	}
+	return targets
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,429 @@
+package publisher
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gopkg.in/yaml.v2"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+var (
+	ErrDebugBundle = fmt.Errorf("debug building bundle")
+	ErrDebugFetch  = fmt.Errorf("debug fetching bundle")
+)
+
+const (
+	debugBundlePath    = "/api/v1/debug/bundle"
+	debugBundleFile    = "target-explorer-bundle.tar.gz"
+	debugRecentEvents  = 200
+	debugRecentChanges = 1000
+	debugWatchBuffer   = 256
+	debugFetchTimeout  = 30 * time.Second
+	debugRedactedValue = "<redacted>"
+)
+
+type debugTarget struct {
+	Address     string            `json:"address"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Managed     bool              `json:"managed"`
+	ContainerID string            `json:"container_id,omitempty"`
+	Group       string            `json:"group,omitempty"`
+}
+
+type debugQuarantined struct {
+	Address string    `json:"address"`
+	Reason  string    `json:"reason"`
+	Since   time.Time `json:"since"`
+}
+
+type debugSnapshot struct {
+	Time        time.Time                   `json:"time"`
+	Desired     map[string]debugTarget      `json:"desired"`
+	Published   map[string]debugTarget      `json:"published"`
+	Quarantined map[string]debugQuarantined `json:"quarantined"`
+}
+
+type debugEvent struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	ContainerID string    `json:"container_id"`
+	Name        string    `json:"name"`
+}
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// debugState keeps a copy of what the consumer last worked with, since the consumer's own
+// maps are only safe to touch from its goroutine
+type debugState struct {
+	mu       sync.Mutex
+	cfg      Config
+	snapshot debugSnapshot
+	events   []debugEvent
+	changes  []change
+	watchers map[chan change]struct{}
+}
+
+func newDebugState(cfg Config) *debugState {
+	return &debugState{cfg: cfg}
+}
+
+// setConfig makes bundles carry a reloaded config
+func (d *debugState) setConfig(cfg Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cfg = cfg
+}
+
+func newDebugEvent(e eventlog.Event) debugEvent {
+	return debugEvent{
+		Time:        e.RecordedAt,
+		Action:      e.Action.String(),
+		ContainerID: e.ContainerID,
+		Name:        e.Name,
+	}
+}
+
+func newDebugTargets(scrapeTargets map[string]target, owners map[string]string) map[string]debugTarget {
+	targets := make(map[string]debugTarget, len(scrapeTargets))
+	for job, t := range scrapeTargets {
+		targets[job] = debugTarget{
+			Address:     t.address,
+			Labels:      t.labels,
+			Managed:     t.managed,
+			ContainerID: owners[job],
+			Group:       t.group,
+		}
+	}
+	return targets
+}
+
+func (d *debugState) recordEvents(events []eventlog.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, e := range events {
+		d.events = append(d.events, newDebugEvent(e))
+	}
+	if len(d.events) > debugRecentEvents {
+		d.events = append([]debugEvent(nil), d.events[len(d.events)-debugRecentEvents:]...)
+	}
+}
+
+func (d *debugState) recordDesired(scrapeTargets map[string]target, owners map[string]string, quarantine map[string]quarantined) {
+	desired := newDebugTargets(scrapeTargets, owners)
+	quarantined := make(map[string]debugQuarantined, len(quarantine))
+	for job, q := range quarantine {
+		quarantined[job] = debugQuarantined{
+			Address: q.target.address,
+			Reason:  q.reason,
+			Since:   q.since,
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.snapshot.Time = time.Now()
+	d.snapshot.Desired = desired
+	d.snapshot.Quarantined = quarantined
+}
+
+func (d *debugState) recordPublished(scrapeTargets map[string]target, owners map[string]string, changes []change) {
+	published := newDebugTargets(scrapeTargets, owners)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.snapshot.Published = published
+
+	d.changes = append(d.changes, changes...)
+	if len(d.changes) > debugRecentChanges {
+		d.changes = append([]change(nil), d.changes[len(d.changes)-debugRecentChanges:]...)
+	}
+
+	for ch := range d.watchers {
+		for _, change := range changes {
+			if !trySend(ch, change) {
+				delete(d.watchers, ch)
+				close(ch)
+				break
+			}
+		}
+	}
+}
+
+// watch streams published changes until unwatch; a watcher that falls behind is dropped
+// and its channel closed rather than holding up the consumer
+func (d *debugState) watch() chan change {
+	ch := make(chan change, debugWatchBuffer)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.watchers == nil {
+		d.watchers = make(map[chan change]struct{})
+	}
+	d.watchers[ch] = struct{}{}
+	return ch
+}
+
+func (d *debugState) unwatch(ch chan change) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.watchers[ch]; ok {
+		delete(d.watchers, ch)
+		close(ch)
+	}
+}
+
+func trySend(ch chan change, c change) bool {
+	select {
+	case ch <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *debugState) published() map[string]debugTarget {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot.Published
+}
+
+func (d *debugState) quarantined() map[string]debugQuarantined {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot.Quarantined
+}
+
+func (d *debugState) recentChanges() []change {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]change(nil), d.changes...)
+}
+
+func (d *debugState) handleBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := d.writeBundle(&buf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", debugBundleFile))
+	w.Write(buf.Bytes())
+}
+
+// writeBundle collects what a bug report needs: desired state, what is on disk, the events
+// behind it and the agent config with secrets removed
+func (d *debugState) writeBundle(w io.Writer) error {
+	d.mu.Lock()
+	state, err := json.MarshalIndent(d.snapshot, "", "  ")
+	if err != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("%v: %s", ErrDebugBundle, err)
+	}
+	events, err := json.MarshalIndent(d.events, "", "  ")
+	running := d.cfg
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugBundle, err)
+	}
+
+	cfg, err := yaml.Marshal(redactConfig(running))
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugBundle, err)
+	}
+
+	files := []bundleFile{
+		{"state.json", state},
+		{"events.json", events},
+		{"config.yaml", cfg},
+	}
+
+	for _, o := range running.outputs() {
+		dir := ""
+		if o.group != "" {
+			dir = filepath.Join("groups", o.group)
+		}
+
+		// a file that can't be parsed can't be redacted either, so it is left out
+		if b, err := os.ReadFile(o.output.PrometheusConfig); err == nil {
+			if redacted, err := redactPrometheusConfig(b); err == nil {
+				files = append(files, bundleFile{filepath.Join(dir, "prometheus.yaml"), redacted})
+			}
+		}
+
+		if o.output.FileSDDir != "" {
+			paths, _ := filepath.Glob(filepath.Join(o.output.FileSDDir, "*"+fileSDExt))
+			for _, path := range paths {
+				if b, err := os.ReadFile(path); err == nil {
+					files = append(files, bundleFile{filepath.Join(dir, "file_sd", filepath.Base(path)), b})
+				}
+			}
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    filepath.Join("target-explorer", f.name),
+			Mode:    0644,
+			Size:    int64(len(f.data)),
+			ModTime: now,
+		})
+		if err == nil {
+			_, err = tw.Write(f.data)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %s", ErrDebugBundle, err)
+		}
+	}
+
+	err = tw.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugBundle, err)
+	}
+	return nil
+}
+
+func redactConfig(cfg Config) Config {
+	cfg.Reload = redactReload(cfg.Reload)
+
+	groups := make(map[string]groupConfig, len(cfg.Groups))
+	for name, gc := range cfg.Groups {
+		if gc.Reload != nil {
+			reload := redactReload(*gc.Reload)
+			gc.Reload = &reload
+		}
+		groups[name] = gc
+	}
+	cfg.Groups = groups
+	return cfg
+}
+
+func redactReload(rc reloadConfig) reloadConfig {
+	if rc.BasicAuth.Password != "" {
+		rc.BasicAuth.Password = debugRedactedValue
+	}
+	if rc.BearerToken != "" {
+		rc.BearerToken = debugRedactedValue
+	}
+	return rc
+}
+
+// prometheusSecretFields hold a secret inline in a Prometheus config, rather than the path of
+// a file holding it
+var prometheusSecretFields = map[string]bool{
+	"password":      true,
+	"bearer_token":  true,
+	"credentials":   true,
+	"client_secret": true,
+	"secret_key":    true,
+	"token":         true,
+}
+
+// redactPrometheusConfig blanks the inline secrets of a rendered prometheus.yaml; the sections
+// of a base config, such as remote_write and alerting, carry them as written
+func redactPrometheusConfig(b []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(redactSecrets(doc, ""))
+}
+
+func redactSecrets(v interface{}, parent string) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i, item := range v {
+			key, _ := item.Key.(string)
+			// header values may be credentials of any kind, as may a tls_config's inline key;
+			// target labels are only names and values
+			secret := prometheusSecretFields[key] || parent == "headers" || (parent == "tls_config" && key == "key")
+			if secret && parent != "labels" && item.Value != nil {
+				v[i].Value = debugRedactedValue
+				continue
+			}
+			v[i].Value = redactSecrets(item.Value, key)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactSecrets(v[i], parent)
+		}
+	}
+	return v
+}
+
+// BundleCommand fetches a debug bundle from a running agent
+func BundleCommand(logger *logrus.Logger, args []string) {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	addr := fs.String("addr", "http://localhost"+listenAddress, "base URL of the running agent")
+	out := fs.String("o", debugBundleFile, "file to write the bundle to")
+	tokenFile := fs.String("token-file", "", "file holding the agent's api token, needed for remote agents")
+	fs.Parse(args)
+
+	err := fetchBundle(*addr, *out, *tokenFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Infof("wrote debug bundle to %s", *out)
+}
+
+func fetchBundle(addr, out, tokenFile string) error {
+	req, err := http.NewRequest(http.MethodGet, addr+debugBundlePath, nil)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugFetch, err)
+	}
+	token, err := readSecret("", tokenFile)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugFetch, err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: debugFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugFetch, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: agent returned %s", ErrDebugFetch, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrDebugFetch, err)
+	}
+	return writeFileAtomic(out, b)
+}
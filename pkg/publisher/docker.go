@@ -0,0 +1,177 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrDockerParseHost = fmt.Errorf("docker parsing host")
+	ErrDockerDialSSH   = fmt.Errorf("docker dialing over ssh")
+	ErrDockerConnect   = fmt.Errorf("docker connecting to the daemon")
+)
+
+const dockerConnectTimeout = 10 * time.Second
+
+var dockerAPIVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// newDockerClient starts from the same DOCKER_HOST, DOCKER_TLS_VERIFY, DOCKER_CERT_PATH and
+// DOCKER_API_VERSION environment as the docker CLI; whatever the config sets takes precedence
+func newDockerClient(cfg dockerConfig) (*client.Client, error) {
+	// a pinned api_version (or DOCKER_API_VERSION) turns negotiation off
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+
+	if cfg.Host != "" {
+		u, err := url.Parse(cfg.Host)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrDockerParseHost, err)
+		}
+
+		if u.Scheme == "ssh" {
+			// like the docker CLI, tunnel the API through `docker system dial-stdio` on the remote host
+			opts = append(opts,
+				client.WithHost("http://docker.example.com"),
+				client.WithDialContext(sshDialer(u)),
+			)
+		} else {
+			opts = append(opts, client.WithHost(cfg.Host))
+		}
+	}
+
+	if cfg.TLS.CAFile != "" || cfg.TLS.CertFile != "" {
+		opts = append(opts, client.WithTLSClientConfig(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+	if cfg.APIVersion != "" {
+		opts = append(opts, client.WithVersion(cfg.APIVersion))
+	}
+	return client.NewClientWithOpts(opts...)
+}
+
+// dialDocker builds the client cfg describes and connects it, as every command talking to
+// the engine does
+func dialDocker(logger *logrus.Logger, cfg dockerConfig) (*client.Client, types.Version, error) {
+	docker, err := newDockerClient(cfg)
+	if err != nil {
+		return nil, types.Version{}, fmt.Errorf("%v: %s", ErrDockerConnect, err)
+	}
+	version, err := connectDocker(context.Background(), docker)
+	if err != nil {
+		return nil, types.Version{}, err
+	}
+	logger.Infof("connected to docker engine %s at %s, using API version %s", version.Version, docker.DaemonHost(), docker.ClientVersion())
+	return docker, version, nil
+}
+
+// connectDocker pings the daemon once at startup, so an unreachable daemon fails the agent
+// before its loops start rather than at the first event, and settles the API version on the
+// highest one both sides speak
+func connectDocker(ctx context.Context, docker *client.Client) (types.Version, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerConnectTimeout)
+	defer cancel()
+
+	ping, err := docker.Ping(ctx)
+	if err != nil {
+		return types.Version{}, fmt.Errorf("%v: %s: %s", ErrDockerConnect, docker.DaemonHost(), err)
+	}
+	docker.NegotiateAPIVersionPing(ping)
+
+	v, err := docker.ServerVersion(ctx)
+	if err != nil {
+		return types.Version{}, fmt.Errorf("%v: %s: API version %s: %s", ErrDockerConnect, docker.DaemonHost(), docker.ClientVersion(), err)
+	}
+	return v, nil
+}
+
+// dockerHostname is the address of the docker host itself, which is where its published ports live
+func dockerHostname(host string) string {
+	u, err := url.Parse(host)
+	if err != nil {
+		return ""
+	}
+
+	switch u.Scheme {
+	case "tcp", "ssh", "http", "https":
+		return u.Hostname()
+	}
+	return ""
+}
+
+func sshDialer(u *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	args := []string{"-o", "BatchMode=yes"}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		cmd := exec.Command("ssh", args...)
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrDockerDialSSH, err)
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrDockerDialSSH, err)
+		}
+
+		err = cmd.Start()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrDockerDialSSH, err)
+		}
+		return &cmdConn{cmd: cmd, stdin: stdin, stdout: stdout, remote: u.Host}, nil
+	}
+}
+
+type cmdConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	remote string
+}
+
+func (c *cmdConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *cmdConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *cmdConn) Close() error {
+	c.stdin.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	return c.cmd.Wait()
+}
+
+func (c *cmdConn) LocalAddr() net.Addr {
+	return cmdAddr("ssh")
+}
+
+func (c *cmdConn) RemoteAddr() net.Addr {
+	return cmdAddr(c.remote)
+}
+
+func (c *cmdConn) SetDeadline(t time.Time) error      { return nil }
+func (c *cmdConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *cmdConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type cmdAddr string
+
+func (a cmdAddr) Network() string { return "ssh" }
+func (a cmdAddr) String() string  { return string(a) }
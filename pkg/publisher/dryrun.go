@@ -0,0 +1,77 @@
+package publisher
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+func (c *consumer) dryRunCommit(scrapeTargets map[string]target) {
+	previous := c.published
+	if previous == nil {
+		var err error
+		previous, err = c.getCurrentState()
+		if err != nil {
+			c.logger.Errorf("%v: %s", ErrConsumerGetCurrentState, err)
+		}
+	}
+
+	for _, job := range sortedJobs(previous) {
+		if _, ok := scrapeTargets[job]; !ok {
+			fmt.Printf("- %s %s\n", job, previous[job].address)
+		}
+	}
+	for _, job := range sortedJobs(scrapeTargets) {
+		current, ok := previous[job]
+		switch {
+		case !ok:
+			fmt.Printf("+ %s %s\n", job, scrapeTargets[job].address)
+		case !current.equal(scrapeTargets[job]):
+			fmt.Printf("~ %s %s -> %s\n", job, current.address, scrapeTargets[job].address)
+		}
+	}
+
+	for _, s := range c.sinks {
+		s.dryRun(scrapeTargets)
+	}
+
+	c.published = scrapeTargets
+	c.debug.recordPublished(scrapeTargets, c.owners, c.changes)
+	c.pending = nil
+	c.changes = nil
+}
+
+func copyTargets(scrapeTargets map[string]target) map[string]target {
+	copied := make(map[string]target, len(scrapeTargets))
+	for job, t := range scrapeTargets {
+		copied[job] = t
+	}
+	return copied
+}
+
+func (s *sink) dryRun(scrapeTargets map[string]target) {
+	out, err := yaml.Marshal(s.render(scrapeTargets))
+	if err != nil {
+		s.log().Errorf("%v: %s", ErrConsumerPublish, err)
+		return
+	}
+	fmt.Printf("--- %s (dry run, not written)\n%s", s.output.PrometheusConfig, out)
+
+	if !s.fileSD.enabled() {
+		return
+	}
+	routed := s.targets(scrapeTargets)
+	for _, job := range sortedJobs(routed) {
+		t := routed[job]
+		if !s.fileSD.holds(t) {
+			continue
+		}
+
+		b, err := s.fileSD.render(job, t)
+		if err != nil {
+			s.logger.WithField("job", job).Errorf("%v: %s", ErrFileSDWrite, err)
+			continue
+		}
+		fmt.Printf("--- %s (dry run, not written)\n%s\n", s.fileSD.fileFor(job), b)
+	}
+}
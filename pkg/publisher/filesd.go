@@ -0,0 +1,217 @@
+package publisher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrFileSDRead   = fmt.Errorf("file_sd reading target files")
+	ErrFileSDWrite  = fmt.Errorf("file_sd writing target file")
+	ErrFileSDRemove = fmt.Errorf("file_sd removing orphaned target file")
+)
+
+const (
+	fileSDJobName = "target-explorer"
+	fileSDExt     = ".json"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+type fileSDConfig struct {
+	Files []string `yaml:"files"`
+}
+
+type fileSDGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+type fileSD struct {
+	logger *logrus.Logger
+	dir    string
+}
+
+func newFileSD(logger *logrus.Logger, dir string) fileSD {
+	return fileSD{logger, dir}
+}
+
+func (fs fileSD) enabled() bool {
+	return fs.dir != ""
+}
+
+// holds reports whether a target is published through a file; a job scraped over TLS or with
+// credentials needs a scrape_config of its own and stays in prometheus.yaml
+func (fs fileSD) holds(t target) bool {
+	return fs.enabled() && t.managed && !t.ownScrapeConfig()
+}
+
+// the job name travels as the job label; Prometheus only sets job from job_name when a target doesn't carry one
+func (fs fileSD) scrapeConfig(configPath string) scrapeConfig {
+	pattern := filepath.Join(fs.dir, "*"+fileSDExt)
+	if rel, err := filepath.Rel(filepath.Dir(configPath), pattern); err == nil && !filepath.IsAbs(fs.dir) {
+		pattern = rel
+	}
+
+	return scrapeConfig{
+		JobName:       fileSDJobName,
+		FileSDConfigs: []fileSDConfig{{Files: []string{pattern}}},
+	}
+}
+
+// fileFor names a job's file after the job, sanitized, and a short hash of the raw name, so
+// jobs that sanitize alike such as a/b and a_b don't overwrite each other
+func (fs fileSD) fileFor(job string) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(job, "_"), "_")
+	sum := sha256.Sum256([]byte(job))
+	return filepath.Join(fs.dir, name+"-"+hex.EncodeToString(sum[:4])+fileSDExt)
+}
+
+func (fs fileSD) read() (map[string]target, error) {
+	stateMap := make(map[string]target, 0)
+
+	files, err := filepath.Glob(filepath.Join(fs.dir, "*"+fileSDExt))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrFileSDRead, err)
+	}
+
+	for _, file := range files {
+		job, t, ok := fs.readFile(file)
+		if ok {
+			stateMap[job] = t
+		}
+	}
+	return stateMap, nil
+}
+
+func (fs fileSD) readFile(file string) (string, target, bool) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		fs.logger.Warnf("%v: %s", ErrFileSDRead, err)
+		return "", target{}, false
+	}
+
+	var groups []fileSDGroup
+	err = json.Unmarshal(b, &groups)
+	if err != nil || len(groups) == 0 || len(groups[0].Targets) == 0 {
+		return "", target{}, false
+	}
+
+	labels := make(map[string]string, len(groups[0].Labels))
+	for name, value := range groups[0].Labels {
+		labels[name] = value
+	}
+
+	job := labels["job"]
+	managed := labels[managedLabel] == "true"
+	delete(labels, "job")
+	delete(labels, managedLabel)
+
+	return job, target{
+		address: groups[0].Targets[0],
+		labels:  labels,
+		managed: managed,
+	}, job != ""
+}
+
+// write reports per-job failures separately so one bad file doesn't hold back the others
+func (fs fileSD) write(scrapeTargets map[string]target) (map[string]error, error) {
+	err := os.MkdirAll(fs.dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrFileSDWrite, err)
+	}
+
+	failed := make(map[string]error)
+	for job, t := range scrapeTargets {
+		if !fs.holds(t) {
+			continue
+		}
+
+		b, err := fs.render(job, t)
+		if err != nil {
+			failed[job] = fmt.Errorf("%v: %s", ErrFileSDWrite, err)
+			continue
+		}
+
+		err = writeFileAtomic(fs.fileFor(job), b)
+		if err != nil {
+			failed[job] = fmt.Errorf("%v: %s", ErrFileSDWrite, err)
+		}
+	}
+	return failed, fs.collect(scrapeTargets)
+}
+
+func (fs fileSD) render(job string, t target) ([]byte, error) {
+	groups := []fileSDGroup{{
+		Targets: []string{t.address},
+		Labels:  t.withLabels(map[string]string{"job": job, managedLabel: "true"}).labels,
+	}}
+	return json.MarshalIndent(groups, "", "  ")
+}
+
+// collect removes target files for jobs outside keep, but only files carrying the managed marker
+func (fs fileSD) collect(keep map[string]target) error {
+	files, err := filepath.Glob(filepath.Join(fs.dir, "*"+fileSDExt))
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrFileSDRemove, err)
+	}
+
+	wanted := make(map[string]bool, len(keep))
+	for job, t := range keep {
+		if fs.holds(t) {
+			wanted[fs.fileFor(job)] = true
+		}
+	}
+
+	for _, file := range files {
+		if wanted[file] {
+			continue
+		}
+
+		job, t, ok := fs.readFile(file)
+		if !ok || !t.managed {
+			fs.logger.Warnf("leaving unmanaged file_sd file %s in place", file)
+			continue
+		}
+
+		err = os.Remove(file)
+		if err != nil {
+			return fmt.Errorf("%v: %s", ErrFileSDRemove, err)
+		}
+		fs.logger.WithField("job", job).Infof("removed orphaned file_sd file %s", file)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,176 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrGitCommit = fmt.Errorf("git committing published config")
+	ErrGitPush   = fmt.Errorf("git pushing published config")
+)
+
+const (
+	gitRemote      = "origin"
+	gitAuthorName  = "target-explorer"
+	gitAuthorEmail = "target-explorer@localhost"
+	gitTimeout     = 30 * time.Second
+)
+
+// gitPublisher commits the outputs that live in a local clone after every publish, so the
+// history of the config doubles as an audit trail that config management can sync from
+type gitPublisher struct {
+	logger *logrus.Logger
+	cfg    gitConfig
+	paths  []string
+}
+
+func newGitPublisher(logger *logrus.Logger, cfg Config) *gitPublisher {
+	if cfg.Git.Repo == "" {
+		return nil
+	}
+
+	// git runs in the clone, so the outputs are passed as absolute paths
+	g := &gitPublisher{logger: logger, cfg: cfg.Git}
+	for _, o := range cfg.outputs() {
+		for _, path := range []string{o.output.PrometheusConfig, o.output.FileSDDir} {
+			if abs, err := filepath.Abs(path); err == nil && path != "" {
+				g.paths = append(g.paths, abs)
+			}
+		}
+	}
+	return g
+}
+
+// publish commits whatever the agent changed in the clone; a publish that left the files as
+// they were commits nothing. Only the managed paths are diffed and committed, whatever else
+// is staged in the clone stays staged
+func (g *gitPublisher) publish(ctx context.Context, r retrier, changes []change) error {
+	// git refuses pathspecs that match nothing, such as a file_sd directory not created yet
+	var paths []string
+	for _, path := range g.paths {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	_, err := g.git(append([]string{"add", "-A", "--"}, paths...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrGitCommit, err)
+	}
+
+	staged, err := g.git(append([]string{"diff", "--cached", "--name-only", "--"}, paths...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrGitCommit, err)
+	}
+	if staged == "" {
+		return nil
+	}
+
+	subject, body := commitMessage(changes)
+	_, err = g.git(append([]string{"-c", "user.name=" + g.cfg.AuthorName, "-c", "user.email=" + g.cfg.AuthorEmail,
+		"commit", "--quiet", "-m", subject, "-m", body, "--"}, paths...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrGitCommit, err)
+	}
+	g.logger.Infof("committed %s to %s", subject, g.cfg.Repo)
+
+	if !g.cfg.Push {
+		return nil
+	}
+
+	ref := "HEAD"
+	if g.cfg.Branch != "" {
+		ref = "HEAD:" + g.cfg.Branch
+	}
+	err = r.do(ctx, "git push", func() error {
+		_, err := g.git("push", "--quiet", g.cfg.Remote, ref)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrGitPush, err)
+	}
+	return nil
+}
+
+func (g *gitPublisher) git(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.cfg.Repo
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", subcommand(args), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// subcommand is the git command args run, skipping the -c settings in front of it
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" {
+			i++
+			continue
+		}
+		return args[i]
+	}
+	return ""
+}
+
+// commitMessage sums the changes up in the subject and lists every one of them in the body
+func commitMessage(changes []change) (string, string) {
+	counts := make(map[string]int)
+	lines := make([]string, 0, len(changes))
+	for _, ch := range changes {
+		counts[ch.Action]++
+		line := fmt.Sprintf("%s %s %s", ch.Action, ch.Job, ch.Target)
+		if ch.Reason != "" {
+			line += " (" + ch.Reason + ")"
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+
+	if len(changes) == 0 {
+		return "Update Prometheus config", "No target changes, the rendered config itself changed."
+	}
+
+	var parts []string
+	for _, action := range []string{changeAdd, changeUpdate, changeRemove} {
+		if n := counts[action]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", action, n))
+		}
+	}
+	return "Update targets: " + strings.Join(parts, ", "), strings.Join(lines, "\n")
+}
+
+// insideRepo reports whether path is within the clone at repo
+func insideRepo(repo, path string) bool {
+	absRepo, err := filepath.Abs(repo)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRepo, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
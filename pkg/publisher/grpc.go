@@ -0,0 +1,323 @@
+package publisher
+
+//go:generate protoc -I../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative api/targetexplorer/v1/targetexplorer.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github/rolandvarga/target-explorer/api/targetexplorer/v1"
+)
+
+var (
+	ErrGRPCListen = fmt.Errorf("grpc server listening")
+	ErrGRPCTLS    = fmt.Errorf("grpc server loading tls")
+)
+
+// grpcControlMethods change the targets or the config; like the HTTP control endpoints they
+// are only served to authorized clients
+var grpcControlMethods = map[string]bool{
+	pb.TargetExplorer_Reconcile_FullMethodName:     true,
+	pb.TargetExplorer_ExcludeTarget_FullMethodName: true,
+	pb.TargetExplorer_ReloadConfig_FullMethodName:  true,
+}
+
+type grpcServer struct {
+	pb.UnimplementedTargetExplorerServer
+
+	logger *logrus.Logger
+	cfg    grpcConfig
+	api    *api
+}
+
+func newGRPCServer(logger *logrus.Logger, cfg grpcConfig, a *api) *grpcServer {
+	return &grpcServer{logger: logger, cfg: cfg, api: a}
+}
+
+func (s *grpcServer) run(ctx context.Context) {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.authorize)}
+	if s.cfg.TLS.CertFile != "" {
+		tlsConf, err := newServerTLSConfig(s.cfg.TLS)
+		if err != nil {
+			s.logger.Error(err)
+			return
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+
+	lis, err := net.Listen("tcp", s.cfg.ListenAddress)
+	if err != nil {
+		s.logger.Errorf("%v: %s", ErrGRPCListen, err)
+		return
+	}
+
+	srv := grpc.NewServer(opts...)
+	pb.RegisterTargetExplorerServer(srv, s)
+
+	go func() {
+		<-ctx.Done()
+
+		// watchers never return on their own, so a graceful stop gets the same deadline as the HTTP server
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(serverShutdownTimeout):
+			srv.Stop()
+		}
+	}()
+
+	s.logger.Infof("grpc listening on %s", s.cfg.ListenAddress)
+	err = srv.Serve(lis)
+	if err != nil {
+		s.logger.Errorf("%v: %s", ErrGRPCListen, err)
+	}
+}
+
+// authorize takes the api token from the authorization metadata, as a bearer token like over HTTP
+func (s *grpcServer) authorize(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !grpcControlMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	var token, remoteAddr string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	err := s.api.authorize(token, remoteAddr)
+	if err == ErrAPIForbidden {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(ctx, req)
+}
+
+func newServerTLSConfig(cfg grpcTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrGRPCTLS, err)
+	}
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrGRPCTLS, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: no certificates found in %s", ErrGRPCTLS, cfg.ClientCAFile)
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConf, nil
+}
+
+func (s *grpcServer) ListTargets(ctx context.Context, req *pb.ListTargetsRequest) (*pb.ListTargetsResponse, error) {
+	limit, offset, err := pageOf(req.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	published := s.api.debug.published()
+	targets := make([]*pb.Target, 0, len(published))
+	for _, job := range sortedKeys(published) {
+		t := published[job]
+		if !hasLabels(t.Labels, req.GetLabels()) {
+			continue
+		}
+		targets = append(targets, &pb.Target{
+			Job:         job,
+			Address:     t.Address,
+			Labels:      t.Labels,
+			Managed:     t.Managed,
+			ContainerId: t.ContainerID,
+			Group:       t.Group,
+		})
+	}
+
+	start, end := pageBounds(len(targets), offset, limit)
+	return &pb.ListTargetsResponse{Total: int32(len(targets)), Targets: targets[start:end]}, nil
+}
+
+func (s *grpcServer) ListEvents(ctx context.Context, req *pb.ListEventsRequest) (*pb.ListEventsResponse, error) {
+	limit, offset, err := pageOf(req.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	pending := s.api.events.Peek()
+	events := make([]*pb.Event, 0, len(pending))
+	for _, e := range pending {
+		events = append(events, &pb.Event{
+			Time:        timestamppb.New(e.RecordedAt),
+			Action:      e.Action.String(),
+			ContainerId: e.ContainerID,
+			Name:        e.Name,
+		})
+	}
+
+	start, end := pageBounds(len(events), offset, limit)
+	return &pb.ListEventsResponse{Total: int32(len(events)), Events: events[start:end]}, nil
+}
+
+func (s *grpcServer) ListChanges(ctx context.Context, req *pb.ListChangesRequest) (*pb.ListChangesResponse, error) {
+	limit, offset, err := pageOf(req.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	recent := s.api.debug.recentChanges()
+	changes := make([]*pb.Change, 0, len(recent))
+	for _, ch := range recent {
+		if req.GetJob() != "" && ch.Job != req.GetJob() {
+			continue
+		}
+		changes = append(changes, changeToProto(ch))
+	}
+
+	start, end := pageBounds(len(changes), offset, limit)
+	return &pb.ListChangesResponse{Total: int32(len(changes)), Changes: changes[start:end]}, nil
+}
+
+func (s *grpcServer) ListQuarantine(ctx context.Context, req *pb.ListQuarantineRequest) (*pb.ListQuarantineResponse, error) {
+	limit, offset, err := pageOf(req.GetPage())
+	if err != nil {
+		return nil, err
+	}
+
+	held := s.api.debug.quarantined()
+	quarantined := make([]*pb.Quarantined, 0, len(held))
+	for _, job := range sortedKeys(held) {
+		q := held[job]
+		quarantined = append(quarantined, &pb.Quarantined{
+			Job:     job,
+			Address: q.Address,
+			Reason:  q.Reason,
+			Since:   timestamppb.New(q.Since),
+		})
+	}
+
+	start, end := pageBounds(len(quarantined), offset, limit)
+	return &pb.ListQuarantineResponse{Total: int32(len(quarantined)), Quarantined: quarantined[start:end]}, nil
+}
+
+func (s *grpcServer) WatchChanges(req *pb.WatchChangesRequest, stream pb.TargetExplorer_WatchChangesServer) error {
+	ch := s.api.debug.watch()
+	defer s.api.debug.unwatch(ch)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case change, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber fell behind, resync with ListTargets")
+			}
+			if req.GetJob() != "" && change.Job != req.GetJob() {
+				continue
+			}
+			err := stream.Send(changeToProto(change))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) Reconcile(ctx context.Context, req *pb.ReconcileRequest) (*pb.ReconcileResponse, error) {
+	s.api.requestReconcile()
+	return &pb.ReconcileResponse{}, nil
+}
+
+func (s *grpcServer) ExcludeTarget(ctx context.Context, req *pb.ExcludeTargetRequest) (*pb.ExcludeTargetResponse, error) {
+	if req.GetDurationSeconds() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "duration_seconds must not be negative")
+	}
+
+	ex, err := s.api.requestExclusion(ctx, req.GetJob(), time.Duration(req.GetDurationSeconds())*time.Second)
+	switch err {
+	case nil:
+	case ErrAPINotPublished:
+		return nil, status.Error(codes.NotFound, err.Error())
+	case ErrAPINotManaged:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return nil, status.FromContextError(err).Err()
+	}
+
+	resp := &pb.ExcludeTargetResponse{}
+	if !ex.until.IsZero() {
+		resp.Until = timestamppb.New(ex.until)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) ReloadConfig(ctx context.Context, req *pb.ReloadConfigRequest) (*pb.ReloadConfigResponse, error) {
+	restart, err := s.api.reload(ctx)
+	if err == ErrConfigNoLoader {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.ReloadConfigResponse{RestartRequired: restart}, nil
+}
+
+func pageOf(page *pb.Page) (int, int, error) {
+	limit, offset := int(page.GetLimit()), int(page.GetOffset())
+	if limit == 0 {
+		limit = apiDefaultLimit
+	}
+	if limit < 0 || limit > apiMaxLimit || offset < 0 {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d and offset non-negative", apiMaxLimit)
+	}
+	return limit, offset, nil
+}
+
+func hasLabels(labels, want map[string]string) bool {
+	for name, value := range want {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func changeToProto(ch change) *pb.Change {
+	return &pb.Change{
+		Time:        timestamppb.New(ch.Time),
+		Action:      ch.Action,
+		Job:         ch.Job,
+		Target:      ch.Target,
+		ContainerId: ch.ContainerID,
+		Reason:      ch.Reason,
+	}
+}
@@ -0,0 +1,159 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+const (
+	healthPingTimeout  = 2 * time.Second
+	streamStallTimeout = 2 * time.Minute
+)
+
+type health struct {
+	docker        producer.Docker
+	engineVersion string
+	apiVersion    string
+
+	mu              sync.Mutex
+	streamConnected bool
+	streamChanged   time.Time
+	consumeCycles   int
+	lastConsume     time.Time
+	producers       map[string]producer.Status
+}
+
+type healthStatus struct {
+	Status        string    `json:"status"`
+	Docker        string    `json:"docker"`
+	DockerEngine  string    `json:"docker_engine"`
+	DockerAPI     string    `json:"docker_api_version"`
+	EventStream   string    `json:"event_stream"`
+	ConsumeCycles int       `json:"consume_cycles"`
+	LastConsume   time.Time `json:"last_consume,omitempty"`
+
+	Producers map[string]producer.Status `json:"producers"`
+}
+
+func newHealth(docker producer.Docker, engineVersion, apiVersion string) *health {
+	return &health{
+		docker:        docker,
+		engineVersion: engineVersion,
+		apiVersion:    apiVersion,
+		streamChanged: time.Now(),
+		producers:     make(map[string]producer.Status),
+	}
+}
+
+func (h *health) StreamUp() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streamConnected = true
+	h.streamChanged = time.Now()
+}
+
+func (h *health) StreamDown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streamConnected {
+		h.streamConnected = false
+		h.streamChanged = time.Now()
+	}
+}
+
+func (h *health) ProducerStarted(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.producers[name]
+	st.State = producer.StateRunning
+	st.LastRun = time.Now()
+	h.producers[name] = st
+}
+
+func (h *health) ProducerStopped(name, state string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.producers[name]
+	st.State = state
+	if err != nil {
+		st.Restarts++
+		st.LastError = err.Error()
+	}
+	h.producers[name] = st
+}
+
+func (h *health) consumed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consumeCycles++
+	h.lastConsume = time.Now()
+}
+
+// a disconnected stream only counts as stalled once reconnecting has failed for a while
+func (h *health) streamStalled() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.streamConnected && time.Since(h.streamChanged) > streamStallTimeout
+}
+
+func (h *health) status(ctx context.Context) (healthStatus, bool, bool) {
+	ctx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+
+	_, pingErr := h.docker.Ping(ctx)
+	stalled := h.streamStalled()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := healthStatus{
+		Status:        "ok",
+		Docker:        "ok",
+		DockerEngine:  h.engineVersion,
+		DockerAPI:     h.apiVersion,
+		EventStream:   "connected",
+		ConsumeCycles: h.consumeCycles,
+		LastConsume:   h.lastConsume,
+		Producers:     make(map[string]producer.Status, len(h.producers)),
+	}
+	for name, p := range h.producers {
+		st.Producers[name] = p
+	}
+	if pingErr != nil {
+		st.Docker = pingErr.Error()
+	}
+	if !h.streamConnected {
+		st.EventStream = "reconnecting"
+	}
+	if stalled {
+		st.EventStream = "stalled"
+	}
+
+	alive := pingErr == nil && !stalled
+	ready := pingErr == nil && h.consumeCycles > 0
+	return st, alive, ready
+}
+
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	st, alive, _ := h.status(r.Context())
+	writeHealth(w, st, alive)
+}
+
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	st, _, ready := h.status(r.Context())
+	writeHealth(w, st, ready)
+}
+
+func writeHealth(w http.ResponseWriter, st healthStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		st.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(st)
+}
@@ -0,0 +1,178 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+var (
+	ErrConfigReload   = fmt.Errorf("config reloading")
+	ErrConfigNoLoader = fmt.Errorf("config reloading without a loader")
+)
+
+// reloadRequest hands a validated config, with the sinks already built from it, to the
+// consumer goroutine; done is closed once the targets were reconciled under it
+type reloadRequest struct {
+	cfg   Config
+	sinks []*sink
+	done  chan struct{}
+}
+
+// SetConfigLoader is where ReloadConfig, and with it POST /api/config/reload, reads the
+// config from; call it before Run
+func (a *Agent) SetConfigLoader(load func() (Config, error)) {
+	a.load = load
+}
+
+// ReloadConfig reads the config through the loader and applies it with Reload
+func (a *Agent) ReloadConfig(ctx context.Context) ([]string, error) {
+	if a.load == nil {
+		return nil, ErrConfigNoLoader
+	}
+	cfg, err := a.load()
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		return nil, fmt.Errorf("%v: %s", ErrConfigReload, err)
+	}
+	return a.Reload(ctx, cfg)
+}
+
+// Reload applies cfg to the agent while Run runs and reconciles the targets under the new
+// filters, job names, event behaviors, label conventions and outputs, keeping the targets,
+// ownership and exclusions it has in memory. Settings only read at startup keep their
+// running value; the names of those cfg changed are returned, they need a restart
+func (a *Agent) Reload(ctx context.Context, cfg Config) ([]string, error) {
+	restart, err := a.reload(ctx, cfg)
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		return nil, fmt.Errorf("%v: %s", ErrConfigReload, err)
+	}
+	configReloads.WithLabelValues("success").Inc()
+	return restart, nil
+}
+
+func (a *Agent) reload(ctx context.Context, cfg Config) ([]string, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	cfg, restart := keepStartupSettings(a.cfg, cfg)
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	// anything that can fail is done here, so a rejected config leaves the agent as it was
+	sinks, err := newSinks(a.logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	req := reloadRequest{cfg, sinks, make(chan struct{})}
+	select {
+	case a.consumer.control.reload <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for _, name := range restart {
+		a.logger.Warnf("config %s changed, restart the agent to apply it", name)
+	}
+	configureLogger(a.logger, cfg.Log)
+	filter := producer.NewFilter(cfg.Filters, producer.NewConventions(cfg.Compat, cfg.Credentials))
+	a.producers.Apply(producer.NewJobNamer(cfg.Identity), filter, producer.NewPolicy(cfg.Events))
+
+	<-req.done
+	return restart, nil
+}
+
+// keepStartupSettings carries the settings only read at startup over from running into cfg,
+// reporting which of them cfg would have changed
+func keepStartupSettings(running, cfg Config) (Config, []string) {
+	restart := []string{}
+	for _, setting := range []struct {
+		name string
+		same bool
+	}{
+		{"listen_address", running.ListenAddress == cfg.ListenAddress},
+		{"api", running.API == cfg.API},
+		{"docker", running.Docker == cfg.Docker},
+		{"producers", running.Producers == cfg.Producers},
+		{"state", running.State == cfg.State},
+		{"grpc", running.GRPC == cfg.GRPC},
+		{"leader_election", running.LeaderElection == cfg.LeaderElection},
+		{"git", running.Git == cfg.Git},
+		{"tracing", running.Tracing == cfg.Tracing},
+	} {
+		if !setting.same {
+			restart = append(restart, setting.name)
+		}
+	}
+
+	cfg.ListenAddress = running.ListenAddress
+	cfg.API = running.API
+	cfg.Docker = running.Docker
+	cfg.Producers = running.Producers
+	cfg.State = running.State
+	cfg.GRPC = running.GRPC
+	cfg.LeaderElection = running.LeaderElection
+	cfg.Git = running.Git
+	cfg.Tracing = running.Tracing
+	// command line flags, not part of the file
+	cfg.DryRun = running.DryRun
+	cfg.NoReload = running.NoReload
+	return cfg, restart
+}
+
+// reload swaps in the settings of a reloaded config, then reconciles so the published
+// targets follow the new rules right away; the republish covers what only changes rendering
+func (c *consumer) reload(ctx context.Context, req reloadRequest) {
+	defer close(req.done)
+
+	cfg := req.cfg
+	for _, s := range req.sinks {
+		s.carryOver(c.sink(s.group))
+	}
+	conventions := producer.NewConventions(cfg.Compat, cfg.Credentials)
+
+	c.sinks = req.sinks
+	c.retrier = newRetrier(c.logger, cfg.Retry)
+	c.pipeline = newPipeline(cfg)
+	c.namer = producer.NewJobNamer(cfg.Identity)
+	c.filter = producer.NewFilter(cfg.Filters, conventions)
+	c.conventions = conventions
+	c.policy = producer.NewPolicy(cfg.Events)
+	c.schedule = cfg.Consume
+	c.interval = cfg.Consume.Interval
+	c.reconcileInterval = cfg.Reconcile.Interval
+	c.gcInterval = cfg.Output.GCInterval
+	c.host = cfg.targetHost()
+	c.addressFamily = cfg.AddressFamily
+	c.audit = newAuditLog(cfg.Audit.Path)
+	c.resolver = cfg.targetResolver()
+	// held back events would be lost with the detector, so it is only replaced when its settings change
+	if c.flaps.threshold != cfg.Throttle.FlapThreshold || c.flaps.window != cfg.Throttle.FlapWindow {
+		c.flaps = newFlapDetector(c.logger, cfg.Throttle)
+	}
+	c.debug.setConfig(cfg)
+	// the new outputs may render a config Prometheus accepts, so a rejected target set gets another try
+	c.rejected = nil
+
+	c.logger.Info("config reloaded, reconciling the targets under the new rules")
+	c.reconcile(ctx)
+	if c.published != nil {
+		c.commit(ctx, copyTargets(c.published))
+	}
+}
+
+// carryOver keeps what the sink replaced for the same group knew: whether its Prometheus
+// still has to be reloaded, and the reloads already spent against an unchanged rate limit
+func (s *sink) carryOver(old *sink) {
+	if old == nil {
+		return
+	}
+	s.reloadNeeded = old.reloadNeeded
+	if s.limiter != nil && old.limiter != nil && s.limiter.max == old.limiter.max && s.limiter.interval == old.limiter.interval {
+		s.limiter = old.limiter
+	}
+}
@@ -0,0 +1,247 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrLeaderLock    = fmt.Errorf("leader election taking lock")
+	ErrLeaderSession = fmt.Errorf("leader election renewing consul session")
+)
+
+const (
+	fileLeaderBackend   = "file"
+	consulLeaderBackend = "consul"
+
+	leaderRetryInterval = 5 * time.Second
+	consulAddress       = "http://127.0.0.1:8500"
+	consulKey           = "target-explorer/leader"
+	consulSessionTTL    = 15 * time.Second
+	consulTimeout       = 5 * time.Second
+)
+
+// elector campaigns for leadership until ctx is done, sending true on leading when it is
+// gained and false when it is lost
+type elector interface {
+	run(ctx context.Context, leading chan<- bool)
+}
+
+func newElector(logger *logrus.Logger, cfg leaderElectionConfig) elector {
+	switch cfg.Backend {
+	case fileLeaderBackend:
+		return fileElector{logger, cfg.LockFile, cfg.RetryInterval}
+	case consulLeaderBackend:
+		return &consulElector{
+			logger: logger,
+			cfg:    cfg.Consul,
+			retry:  cfg.RetryInterval,
+			client: &http.Client{Timeout: consulTimeout},
+		}
+	}
+	return nil
+}
+
+// fileElector holds an exclusive lock on a file for as long as the agent runs, so only agents
+// sharing a filesystem can stand in for each other
+type fileElector struct {
+	logger *logrus.Logger
+	path   string
+	retry  time.Duration
+}
+
+func (e fileElector) run(ctx context.Context, leading chan<- bool) {
+	for {
+		f, err := lockFile(e.path)
+		if err == nil {
+			defer f.Close()
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			leading <- true
+			<-ctx.Done()
+			return
+		}
+		if err != errLockHeld {
+			e.logger.Errorf("%v: %s", ErrLeaderLock, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.retry):
+		}
+	}
+}
+
+// consulElector holds a Consul KV key with a session that expires unless it is renewed,
+// so a leader that dies or loses Consul hands over after at most the session TTL
+type consulElector struct {
+	logger  *logrus.Logger
+	cfg     consulConfig
+	retry   time.Duration
+	client  *http.Client
+	session string
+}
+
+type consulSession struct {
+	ID string `json:"ID"`
+}
+
+type consulKV struct {
+	Session string `json:"Session"`
+}
+
+func (e *consulElector) run(ctx context.Context, leading chan<- bool) {
+	defer e.resign()
+
+	for {
+		acquired, err := e.acquire(ctx)
+		if err != nil {
+			e.logger.Errorf("%v: %s", ErrLeaderLock, err)
+		}
+		if acquired {
+			leading <- true
+			err = e.hold(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			e.logger.Warnf("%v: %s", ErrLeaderSession, err)
+			leading <- false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.retry):
+		}
+	}
+}
+
+func (e *consulElector) acquire(ctx context.Context) (bool, error) {
+	if e.session == "" {
+		body, _ := json.Marshal(map[string]string{"Name": "target-explorer", "TTL": e.cfg.SessionTTL.String(), "Behavior": "release"})
+		var session consulSession
+		err := e.do(ctx, http.MethodPut, "/v1/session/create", body, &session)
+		if err != nil {
+			return false, err
+		}
+		e.session = session.ID
+	}
+
+	hostname, _ := os.Hostname()
+	var acquired bool
+	err := e.do(ctx, http.MethodPut, "/v1/kv/"+e.cfg.Key+"?acquire="+url.QueryEscape(e.session), []byte(hostname), &acquired)
+	if err != nil {
+		// the session may have expired in the meantime; start over with a new one
+		e.session = ""
+		return false, err
+	}
+	return acquired, nil
+}
+
+// hold renews the session at half its TTL and checks the key is still ours, returning once it isn't
+func (e *consulElector) hold(ctx context.Context) error {
+	ticker := time.NewTicker(e.cfg.SessionTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		err := e.do(ctx, http.MethodPut, "/v1/session/renew/"+e.session, nil, nil)
+		if err != nil {
+			e.session = ""
+			return err
+		}
+
+		var kvs []consulKV
+		err = e.do(ctx, http.MethodGet, "/v1/kv/"+e.cfg.Key, nil, &kvs)
+		if err != nil {
+			return err
+		}
+		if len(kvs) == 0 || kvs[0].Session != e.session {
+			return fmt.Errorf("key %s is no longer held by this agent", e.cfg.Key)
+		}
+	}
+}
+
+// resign hands the key over right away instead of letting the standby wait out the TTL
+func (e *consulElector) resign() {
+	if e.session == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), consulTimeout)
+	defer cancel()
+
+	e.do(ctx, http.MethodPut, "/v1/kv/"+e.cfg.Key+"?release="+url.QueryEscape(e.session), nil, nil)
+	e.do(ctx, http.MethodPut, "/v1/session/destroy/"+e.session, nil, nil)
+}
+
+func (e *consulElector) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, e.cfg.Address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	token, err := readSecret("", e.cfg.TokenFile)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul %s %s: %s %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// lead switches between publishing and standing by; a standby keeps its target set warm so a
+// new leader publishes right away rather than after a full scan
+func (c *consumer) lead(ctx context.Context, leading bool) {
+	if !leading {
+		c.logger.Warn("lost leadership, standing by")
+		c.leading = false
+		leader.Set(0)
+		return
+	}
+
+	c.logger.Info("elected leader, publishing the warm target cache")
+	c.leading = true
+	leader.Set(1)
+
+	if c.published != nil {
+		c.commit(ctx, copyTargets(c.published))
+	}
+	c.reconcile(ctx)
+}
+
+// standby keeps what would have been published as the warm cache; the leader writes the
+// output and the audit log
+func (c *consumer) standby(scrapeTargets map[string]target) {
+	c.published = scrapeTargets
+	c.pending = nil
+	c.changes = nil
+}
@@ -0,0 +1,97 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrListDiscover = fmt.Errorf("list discovering targets")
+
+// ListCommand prints the targets of the running containers
+func ListCommand(logger *logrus.Logger, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	flags := newAgentFlags(fs)
+	output := fs.String("o", "table", "output format, table or json")
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *output != "table" && *output != "json" {
+		logger.Fatalf("unknown output format %q", *output)
+	}
+	configureLogger(logger, cfg.Log)
+
+	c, err := newDiscoverer(logger, cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	discovered, failed, err := c.discover(context.Background())
+	if err != nil {
+		logger.Fatalf("%v: %s", ErrListDiscover, err)
+	}
+	for job, err := range failed {
+		logger.WithField("job", job).Errorf("%v: %s", ErrListDiscover, err)
+	}
+
+	targets := make([]apiTarget, 0, len(discovered))
+	for job, d := range discovered {
+		targets = append(targets, apiTarget{job, debugTarget{
+			Address:     d.target.address,
+			Labels:      d.target.labels,
+			Managed:     d.target.managed,
+			ContainerID: d.containerID,
+		}})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Job < targets[j].Job })
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(targets)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tADDRESS\tCONTAINER\tLABELS")
+	for _, t := range targets {
+		fmt.Fprintf(tw, "%s\t%s\t%.12s\t%s\n", t.Job, t.Address, t.ContainerID, formatLabels(t.Labels))
+	}
+	tw.Flush()
+}
+
+// newDiscoverer is a consumer that only inspects containers: without the state store, whose
+// lock the running agent holds, and without tracing, so list runs next to the agent
+func newDiscoverer(logger *logrus.Logger, cfg Config) (*consumer, error) {
+	docker, version, err := dialDocker(logger, cfg.Docker)
+	if err != nil {
+		return nil, err
+	}
+	sinks, err := newSinks(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := newHealth(docker, version.Version, docker.ClientVersion())
+	return newConsumer(logger, docker, sinks, h, nil, newDebugState(cfg), cfg), nil
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, name := range sortedKeys(labels) {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package publisher
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var errLockHeld = fmt.Errorf("lock held by another agent")
+
+// lockFile takes an exclusive advisory lock, held until the returned file is closed or the process exits
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, errLockHeld
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	err = f.Truncate(0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,12 @@
+package publisher
+
+import (
+	"fmt"
+	"os"
+)
+
+var errLockHeld = fmt.Errorf("lock held by another agent")
+
+func lockFile(path string) (*os.File, error) {
+	return nil, fmt.Errorf("file leader election is not supported on windows, use the consul backend")
+}
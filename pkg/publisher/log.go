@@ -0,0 +1,61 @@
+package publisher
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+const (
+	textLogFormat = "text"
+	jsonLogFormat = "json"
+)
+
+// NewLogger is the logger every command starts with, until the config's log section applies
+func NewLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(newTextFormatter())
+	logger.SetOutput(os.Stdout)
+	logger.SetLevel(logrus.InfoLevel)
+	return logger
+}
+
+func (lc logConfig) validate() error {
+	_, err := logrus.ParseLevel(lc.Level)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrConfigInvalid, err)
+	}
+	if lc.Format != textLogFormat && lc.Format != jsonLogFormat {
+		return fmt.Errorf("%v: unknown log format %q", ErrConfigInvalid, lc.Format)
+	}
+	return nil
+}
+
+func configureLogger(logger *logrus.Logger, cfg logConfig) {
+	level, _ := logrus.ParseLevel(cfg.Level)
+	logger.SetLevel(level)
+
+	// set in both cases, a reloaded config may switch back to text
+	if cfg.Format == jsonLogFormat {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(newTextFormatter())
+	}
+}
+
+func newTextFormatter() logrus.Formatter {
+	return &logrus.TextFormatter{
+		FullTimestamp: true,
+	}
+}
+
+func eventFields(e eventlog.Event) logrus.Fields {
+	return logrus.Fields{
+		"container_id": e.ContainerID,
+		"job":          e.Name,
+		"action":       e.Action.String(),
+	}
+}
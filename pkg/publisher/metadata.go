@@ -0,0 +1,109 @@
+package publisher
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const metadataLabelPrefix = "container_"
+
+var metadataFields = map[string]func(types.ContainerJSON) string{
+	"restart_policy": func(inspect types.ContainerJSON) string {
+		if inspect.HostConfig == nil {
+			return ""
+		}
+		return string(inspect.HostConfig.RestartPolicy.Name)
+	},
+	"created": func(inspect types.ContainerJSON) string {
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		if err != nil {
+			return inspect.Created
+		}
+		return created.UTC().Format(time.RFC3339)
+	},
+	"networks": func(inspect types.ContainerJSON) string {
+		if inspect.NetworkSettings == nil {
+			return ""
+		}
+		networks := make([]string, 0, len(inspect.NetworkSettings.Networks))
+		for name := range inspect.NetworkSettings.Networks {
+			networks = append(networks, name)
+		}
+		sort.Strings(networks)
+		return strings.Join(networks, ",")
+	},
+	"image": func(inspect types.ContainerJSON) string {
+		if inspect.Config == nil {
+			return ""
+		}
+		return inspect.Config.Image
+	},
+	"hostname": func(inspect types.ContainerJSON) string {
+		if inspect.Config == nil {
+			return ""
+		}
+		return inspect.Config.Hostname
+	},
+}
+
+func metadataLabels(inspect types.ContainerJSON, fields []string) map[string]string {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(fields))
+	for _, field := range fields {
+		if value := metadataFields[field](inspect); value != "" {
+			labels[metadataLabelPrefix+field] = value
+		}
+	}
+	return labels
+}
+
+// runtimeStats are the container names gauges were set for, by container ID, so the gauges
+// go away with the container
+var runtimeStats = struct {
+	sync.Mutex
+	names map[string]string
+}{names: make(map[string]string)}
+
+// recordRuntimeStats exports restart and OOM history as gauges per container, kept current by
+// the periodic reconcile re-inspecting every target. They are not target labels: those would
+// change the identity of every series scraped from the target on each restart
+func recordRuntimeStats(inspect types.ContainerJSON) {
+	oomKilled := inspect.State != nil && inspect.State.OOMKilled
+	name := strings.TrimPrefix(inspect.Name, "/")
+
+	runtimeStats.Lock()
+	defer runtimeStats.Unlock()
+	if previous, ok := runtimeStats.names[inspect.ID]; ok && previous != name {
+		deleteRuntimeStats(previous)
+	}
+	runtimeStats.names[inspect.ID] = name
+
+	containerRestarts.WithLabelValues(name).Set(float64(inspect.RestartCount))
+	if oomKilled {
+		containerOOMKilled.WithLabelValues(name).Set(1)
+	} else {
+		containerOOMKilled.WithLabelValues(name).Set(0)
+	}
+}
+
+// forgetRuntimeStats drops the gauges of a container that no longer backs a target
+func forgetRuntimeStats(containerID string) {
+	runtimeStats.Lock()
+	defer runtimeStats.Unlock()
+	if name, ok := runtimeStats.names[containerID]; ok {
+		deleteRuntimeStats(name)
+		delete(runtimeStats.names, containerID)
+	}
+}
+
+func deleteRuntimeStats(name string) {
+	containerRestarts.DeleteLabelValues(name)
+	containerOOMKilled.DeleteLabelValues(name)
+}
@@ -0,0 +1,108 @@
+package publisher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "target_explorer"
+
+var (
+	containerRestarts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_restart_count",
+		Help:      "Restart count of scrape target containers as of their last inspect (runtime_stats processor).",
+	}, []string{"container"})
+
+	containerOOMKilled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "container_oom_killed",
+		Help:      "Whether a scrape target container was last stopped by the OOM killer (runtime_stats processor).",
+	}, []string{"container"})
+
+	consumeCycles = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "consume_cycles_total",
+		Help:      "Consume cycles that processed at least one event or pending target set.",
+	})
+
+	diffSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "diff_size",
+		Help:      "Number of target additions and removals computed per consume cycle.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 25, 50, 100},
+	})
+
+	publishedTargets = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "published_targets",
+		Help:      "Scrape targets in the last successfully published config.",
+	})
+
+	quarantinedJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "quarantined_jobs",
+		Help:      "Jobs held back from publishing because their output is invalid.",
+	})
+
+	publishFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "publish_failures_total",
+		Help:      "Config writes that failed after all retries.",
+	})
+
+	invalidConfigs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "invalid_configs_total",
+		Help:      "Rendered configs refused before writing because Prometheus would reject them.",
+	})
+
+	rollbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_rollbacks_total",
+		Help:      "Restores of the last loaded config after a failed reload, by outcome.",
+	}, []string{"status"})
+
+	reloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reloads_total",
+		Help:      "Reload requests sent to Prometheus, by outcome.",
+	}, []string{"status"})
+
+	reloadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reload_duration_seconds",
+		Help:      "Latency of reload requests sent to Prometheus.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	reloadsThrottled = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reloads_throttled_total",
+		Help:      "Reloads deferred to a later cycle because the reload rate limit was reached.",
+	})
+
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Reloads of the agent's own configuration, by outcome.",
+	}, []string{"status"})
+
+	eventsCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_coalesced_total",
+		Help:      "Events of flapping containers held back instead of applied.",
+	})
+
+	flappingContainers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "flapping_containers",
+		Help:      "Containers whose events are held back until they stop flapping.",
+	})
+
+	leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "Whether this agent publishes targets; a standby under leader election reports 0.",
+	})
+)
@@ -0,0 +1,406 @@
+package publisher
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+
+	"gopkg.in/yaml.v2"
+
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+var (
+	ErrMigrateReadConfig     = fmt.Errorf("migrate reading prometheus config")
+	ErrMigrateListContainers = fmt.Errorf("migrate listing containers")
+	ErrMigrateMarkJobs       = fmt.Errorf("migrate marking managed jobs")
+	ErrMigrateWriteConfig    = fmt.Errorf("migrate writing prometheus config")
+)
+
+type migration struct {
+	logger *logrus.Logger
+	docker producer.Docker
+	namer  producer.JobNamer
+	filter producer.Filter
+	host   string
+}
+
+type migrationMatch struct {
+	job       string
+	target    string
+	container types.Container
+	reason    string
+	// port is the container port serving the target, e.g. 9100/tcp, if one does
+	port string
+	path string
+}
+
+// MigrateCommand adopts the jobs of a hand-written Prometheus config
+func MigrateCommand(logger *logrus.Logger, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags := newAgentFlags(fs)
+	configPath := fs.String("prometheus-config", prometheusConfigPath, "hand-written prometheus config to migrate")
+	write := fs.Bool("write", false, "mark matched jobs as agent-managed in the prometheus config")
+	fs.Parse(args)
+
+	// the engine is reached the way the agent reaches it: same host, TLS and API version
+	cfg, err := flags.load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	configureLogger(logger, cfg.Log)
+
+	docker, _, err := dialDocker(logger, cfg.Docker)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	filter := producer.NewFilter(cfg.Filters, producer.NewConventions(cfg.Compat, cfg.Credentials))
+	m := migration{logger, docker, producer.NewJobNamer(cfg.Identity), filter, cfg.targetHost()}
+	err = m.run(*configPath, *write)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func (m migration) run(configPath string, write bool) error {
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrMigrateReadConfig, err)
+	}
+
+	var promConf prometheusConf
+	err = yaml.Unmarshal(raw, &promConf)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrMigrateReadConfig, err)
+	}
+
+	containers, err := m.docker.ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrMigrateListContainers, err)
+	}
+
+	matches, complete := m.match(promConf, containers)
+	m.emitLabels(matches)
+
+	if !write || len(matches) == 0 {
+		return nil
+	}
+
+	jobs := m.adoptable(matches, complete)
+	if len(jobs) == 0 {
+		m.logger.Warn("no job is discovered by the agent yet, nothing marked as agent-managed")
+		return nil
+	}
+
+	marked, err := markManagedJobs(raw, jobs)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrMigrateMarkJobs, err)
+	}
+
+	// the live config may be read by a reload at any time
+	err = writeFileAtomic(configPath+backupSuffix, raw)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrMigrateWriteConfig, err)
+	}
+	err = writeFileAtomic(configPath, marked)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrMigrateWriteConfig, err)
+	}
+
+	m.logger.Infof("marked %d jobs as agent-managed in %s", len(jobs), configPath)
+	return nil
+}
+
+// match pairs the static targets with containers, reporting which jobs had every target matched
+func (m migration) match(promConf prometheusConf, containers []types.Container) ([]migrationMatch, map[string]bool) {
+	matches := make([]migrationMatch, 0)
+	complete := make(map[string]bool)
+
+	for _, scrapeConfig := range promConf.ScrapeConfigs {
+		matched := false
+		complete[scrapeConfig.JobName] = len(scrapeConfig.FileSDConfigs) == 0
+
+		for _, staticConfig := range scrapeConfig.StaticConfigs {
+			for _, target := range staticConfig.Targets {
+				container, reason, ok := m.matchContainer(scrapeConfig.JobName, target, containers)
+				if !ok {
+					complete[scrapeConfig.JobName] = false
+					continue
+				}
+
+				port, _ := m.containerPort(target, container)
+				matches = append(matches, migrationMatch{
+					job:       scrapeConfig.JobName,
+					target:    target,
+					container: container,
+					reason:    reason,
+					port:      port,
+					path:      scrapeConfig.MetricsPath,
+				})
+				matched = true
+			}
+		}
+
+		if !matched {
+			m.logger.Warnf("job %q: no running container matches its targets", scrapeConfig.JobName)
+			complete[scrapeConfig.JobName] = false
+		}
+	}
+	return matches, complete
+}
+
+// matchContainer only trusts a published port for targets on this Docker host; the same port
+// on another machine is a different service
+func (m migration) matchContainer(job, target string, containers []types.Container) (types.Container, string, bool) {
+	if host, portStr, err := net.SplitHostPort(target); err == nil && m.local(host) {
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err == nil {
+			for _, container := range containers {
+				for _, p := range container.Ports {
+					if p.Type == "tcp" && uint64(p.PublicPort) == port {
+						return container, "port " + portStr, true
+					}
+				}
+			}
+		}
+	}
+
+	for _, container := range containers {
+		if container.Labels[producer.ComposeServiceLabel] == job {
+			return container, "compose service name", true
+		}
+		for _, name := range container.Names {
+			if strings.TrimPrefix(name, "/") == job {
+				return container, "container name", true
+			}
+		}
+	}
+	return types.Container{}, "", false
+}
+
+// containerPort finds the container port behind target: the one published on its port for a
+// target on this host, otherwise the one it names directly, as a target on a compose network does
+func (m migration) containerPort(target string, container types.Container) (string, bool) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", false
+	}
+
+	if m.local(host) {
+		for _, p := range container.Ports {
+			if p.Type == "tcp" && uint64(p.PublicPort) == port {
+				return fmt.Sprintf("%d/tcp", p.PrivatePort), true
+			}
+		}
+	}
+	for _, p := range container.Ports {
+		if p.Type == "tcp" && uint64(p.PrivatePort) == port {
+			return fmt.Sprintf("%d/tcp", p.PrivatePort), true
+		}
+	}
+	return "", false
+}
+
+func (m migration) local(host string) bool {
+	if host == "localhost" || host == dockerHostAddress || host == m.host {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// adoptable are the jobs safe to mark as managed: every target matched a container the agent
+// already discovers under that job name, so the next reconcile keeps them rather than deleting them
+func (m migration) adoptable(matches []migrationMatch, complete map[string]bool) map[string]bool {
+	jobs := make(map[string]bool)
+	partial := make(map[string]bool)
+	for _, match := range matches {
+		if !complete[match.job] {
+			partial[match.job] = true
+			continue
+		}
+		if _, ok := jobs[match.job]; !ok {
+			jobs[match.job] = true
+		}
+		if !m.discovers(match.job, match.container) {
+			jobs[match.job] = false
+		}
+	}
+
+	for _, job := range sortedKeys(jobs) {
+		if !jobs[job] {
+			m.logger.Warnf("job %q: not marked as agent-managed, the agent doesn't discover it under this name yet; deploy the labels above first", job)
+			delete(jobs, job)
+		}
+	}
+	for _, job := range sortedKeys(partial) {
+		m.logger.Warnf("job %q: not marked as agent-managed, some of its targets aren't matched to a container", job)
+	}
+	return jobs
+}
+
+func (m migration) discovers(job string, container types.Container) bool {
+	if !m.filter.Selects(producer.ContainerSubject(container)) {
+		return false
+	}
+	return m.namer.Name(identityOf(container, container.Labels)) == job
+}
+
+func identityOf(container types.Container, labels map[string]string) producer.ContainerIdentity {
+	return producer.ContainerIdentity{ID: container.ID, Labels: labels, Name: container.Names[0], Image: container.Image}
+}
+
+func (m migration) emitLabels(matches []migrationMatch) {
+	services := make(yaml.MapSlice, 0)
+	seen := make(map[string]bool)
+
+	for _, match := range matches {
+		name := strings.TrimPrefix(match.container.Names[0], "/")
+		m.logger.Infof("job %q: target %s matched container %s by %s", match.job, match.target, name, match.reason)
+
+		port := match.port
+		if port == "" {
+			m.logger.Warnf("job %q: no port of container %s serves target %s, add a scrape_port label naming its metrics port", match.job, name, match.target)
+			port = producer.MetricsPort
+		}
+		if !publishes(match.container, port) {
+			m.logger.Warnf("job %q: container %s does not publish %s, the agent will not discover it", match.job, name, port)
+		}
+
+		labels := m.scrapeLabels(match)
+		service, ok := match.container.Labels[producer.ComposeServiceLabel]
+		if !ok {
+			m.logger.Warnf("job %q: container %s is not part of a compose project, add the labels %s to it manually", match.job, name, formatLabels(labels))
+			continue
+		}
+		if seen[service] {
+			continue
+		}
+		seen[service] = true
+
+		services = append(services, yaml.MapItem{
+			Key: service,
+			Value: yaml.MapSlice{
+				{Key: "labels", Value: labels},
+			},
+		})
+	}
+
+	if len(services) == 0 {
+		return
+	}
+
+	out, err := yaml.Marshal(yaml.MapSlice{{Key: "services", Value: services}})
+	if err != nil {
+		m.logger.Error(err)
+		return
+	}
+	fmt.Print(string(out))
+}
+
+// scrapeLabels opt the container in, pointing the agent at the port and path the job scraped
+// when they aren't the defaults. A container the agent would name differently keeps the job
+// name through scrape_job, so its series, dashboards and alerts don't change
+func (m migration) scrapeLabels(match migrationMatch) map[string]string {
+	labels := map[string]string{"scrape_target": "true"}
+	if match.port != "" && match.port != producer.MetricsPort {
+		labels["scrape_port"] = strings.TrimSuffix(match.port, "/tcp")
+	}
+	if match.path != "" && match.path != "/metrics" {
+		labels["scrape_path"] = match.path
+	}
+
+	c := match.container
+	if m.namer.Name(identityOf(c, c.Labels)) == match.job {
+		return labels
+	}
+	labels["scrape_job"] = match.job
+
+	relabeled := make(map[string]string, len(c.Labels)+1)
+	for name, value := range c.Labels {
+		relabeled[name] = value
+	}
+	relabeled["scrape_job"] = match.job
+	if name := m.namer.Name(identityOf(c, relabeled)); name != match.job {
+		m.logger.Warnf("job %q: the agent would name it %q even with scrape_job set, check identity.precedence", match.job, name)
+	}
+	return labels
+}
+
+func publishes(container types.Container, port string) bool {
+	for _, p := range container.Ports {
+		if fmt.Sprintf("%d/%s", p.PrivatePort, p.Type) == port && p.PublicPort != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// markManagedJobs works on the generic document so sections the agent doesn't model survive the rewrite
+func markManagedJobs(raw []byte, jobs map[string]bool) ([]byte, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	scrapeConfigs, _ := mapSliceGet(doc, "scrape_configs").([]interface{})
+	for _, item := range scrapeConfigs {
+		scrapeConfig, ok := item.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+
+		job, _ := mapSliceGet(scrapeConfig, "job_name").(string)
+		if !jobs[job] {
+			continue
+		}
+
+		staticConfigs, _ := mapSliceGet(scrapeConfig, "static_configs").([]interface{})
+		for i, item := range staticConfigs {
+			staticConfig, ok := item.(yaml.MapSlice)
+			if !ok {
+				continue
+			}
+
+			labels, _ := mapSliceGet(staticConfig, "labels").(yaml.MapSlice)
+			labels = mapSliceSet(labels, managedLabel, "true")
+			staticConfigs[i] = mapSliceSet(staticConfig, "labels", labels)
+		}
+	}
+	return yaml.Marshal(doc)
+}
+
+func mapSliceGet(ms yaml.MapSlice, key string) interface{} {
+	for _, item := range ms {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func mapSliceSet(ms yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range ms {
+		if item.Key == key {
+			ms[i].Value = value
+			return ms
+		}
+	}
+	return append(ms, yaml.MapItem{Key: key, Value: value})
+}
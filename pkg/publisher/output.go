@@ -0,0 +1,257 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrOutputUnknownGroup = fmt.Errorf("output routing target to unknown group")
+
+// sink is one Prometheus server fed by the agent: the default output, or a group that targets
+// opt into with a scrape_group label. A sink without a reloader is never reloaded by the agent
+type sink struct {
+	logger   *logrus.Logger
+	group    string
+	output   outputConfig
+	base     *baseConfig
+	fileSD   fileSD
+	reloader *reloader
+	limiter  *reloadLimiter
+
+	// reloadNeeded is set once prometheus.yaml changed on disk and cleared by a successful reload
+	reloadNeeded bool
+}
+
+func newSinks(logger *logrus.Logger, cfg Config) ([]*sink, error) {
+	// in sidecar mode a config-reloader watching the shared volume triggers the reload,
+	// and batch runs may leave reloading to the caller
+	skipReload := cfg.Mode == sidecarMode || cfg.NoReload
+
+	var sinks []*sink
+	for _, o := range cfg.outputs() {
+		s := &sink{
+			logger: logger,
+			group:  o.group,
+			output: o.output,
+			fileSD: newFileSD(logger, o.output.FileSDDir),
+		}
+		if o.output.BaseConfig != "" {
+			base, err := loadBaseConfig(o.output.BaseConfig)
+			if err != nil {
+				return nil, err
+			}
+			s.base = base
+		}
+		if o.reload != nil && o.reload.Endpoint != "" && !skipReload {
+			rl, err := newReloader(*o.reload)
+			if err != nil {
+				return nil, err
+			}
+			s.reloader = &rl
+			s.limiter = newReloadLimiter(cfg.Throttle)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+func (s *sink) log() *logrus.Entry {
+	return s.logger.WithField("output", s.output.PrometheusConfig)
+}
+
+// targets picks the targets routed to this sink
+func (s *sink) targets(scrapeTargets map[string]target) map[string]target {
+	routed := make(map[string]target, len(scrapeTargets))
+	for job, t := range scrapeTargets {
+		if t.group == s.group {
+			routed[job] = t
+		}
+	}
+	return routed
+}
+
+func (s *sink) state() (map[string]target, error) {
+	stateMap := make(map[string]target, 0)
+
+	f, err := os.ReadFile(s.output.PrometheusConfig)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stateMap, nil
+		}
+		return nil, err
+	}
+
+	var prometheusConf prometheusConf
+
+	err = yaml.Unmarshal(f, &prometheusConf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scrapeConfig := range prometheusConf.ScrapeConfigs {
+		if len(scrapeConfig.StaticConfigs) == 0 || len(scrapeConfig.StaticConfigs[0].Targets) == 0 {
+			continue
+		}
+
+		// jobs of the base config are rendered from it, not taken over as targets
+		if s.base != nil && s.base.jobs[scrapeConfig.JobName] {
+			continue
+		}
+
+		staticConfig := scrapeConfig.StaticConfigs[0]
+		managed := staticConfig.Labels[managedLabel] == "true"
+		delete(staticConfig.Labels, managedLabel)
+
+		stateMap[scrapeConfig.JobName] = target{
+			address: staticConfig.Targets[0],
+			labels:  staticConfig.Labels,
+			managed: managed,
+			tls:     newScrapeTLS(scrapeConfig),
+			auth:    newScrapeAuth(scrapeConfig),
+			group:   s.group,
+		}
+	}
+
+	if s.fileSD.enabled() {
+		fileTargets, err := s.fileSD.read()
+		if err != nil {
+			return nil, err
+		}
+		for job, t := range fileTargets {
+			t.group = s.group
+			stateMap[job] = t
+		}
+	}
+	return stateMap, nil
+}
+
+func (s *sink) render(scrapeTargets map[string]target) prometheusConf {
+	var promConf prometheusConf
+	promConf.Global.ScrapeInterval = globalScrapeInterval
+	if s.base != nil {
+		promConf.base = s.base
+		promConf.Global.ScrapeInterval = s.base.scrapeInterval
+	}
+
+	if s.fileSD.enabled() {
+		promConf.ScrapeConfigs = append(promConf.ScrapeConfigs, s.fileSD.scrapeConfig(s.output.PrometheusConfig))
+	}
+
+	routed := s.targets(scrapeTargets)
+	for _, jobName := range sortedJobs(routed) {
+		target := routed[jobName]
+		if s.fileSD.holds(target) {
+			continue
+		}
+		if s.base != nil && s.base.jobs[jobName] {
+			s.log().Warnf("job %s is defined by the base config, leaving its target out", jobName)
+			continue
+		}
+
+		labels := target.labels
+		if target.managed {
+			labels = target.withLabels(map[string]string{managedLabel: "true"}).labels
+		}
+
+		sc := scrapeConfig{
+			JobName: jobName,
+			StaticConfigs: []staticConfig{
+				{
+					Targets: []string{target.address},
+					Labels:  labels,
+				},
+			},
+		}
+		promConf.ScrapeConfigs = append(promConf.ScrapeConfigs, withAuth(withTLS(sc, target.tls), target.auth))
+	}
+	return promConf
+}
+
+// Prometheus has no runtime API for pushing targets, so the cheapest update is none at all:
+// an unchanged prometheus.yaml is neither rewritten nor reloaded, and file_sd changes are
+// picked up by Prometheus' file watcher
+func (s *sink) writeConfig(promConf prometheusConf) error {
+	out, err := yaml.Marshal(promConf)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(s.output.PrometheusConfig)
+	if err == nil && bytes.Equal(current, out) {
+		return nil
+	}
+
+	// only a config Prometheus has loaded is worth rolling back to
+	if err == nil && !s.reloadNeeded && s.reloader != nil {
+		backupErr := writeFileAtomic(s.output.PrometheusConfig+backupSuffix, current)
+		if backupErr != nil {
+			s.log().Warnf("%v: %s", ErrConsumerBackup, backupErr)
+		}
+	}
+
+	err = writeFileAtomic(s.output.PrometheusConfig, out)
+	if err != nil {
+		return err
+	}
+	s.reloadNeeded = true
+	return nil
+}
+
+// rollback restores the config Prometheus last loaded after a failed reload, so what is on disk
+// matches what it runs; the rejected target set stays queued for the next cycle
+func (s *sink) rollback(ctx context.Context, r retrier) {
+	b, err := os.ReadFile(s.output.PrometheusConfig + backupSuffix)
+	if err != nil {
+		s.log().Errorf("%v: %s", ErrConsumerRollback, err)
+		rollbacks.WithLabelValues("failure").Inc()
+		return
+	}
+
+	err = writeFileAtomic(s.output.PrometheusConfig, b)
+	if err != nil {
+		s.log().Errorf("%v: %s", ErrConsumerRollback, err)
+		rollbacks.WithLabelValues("failure").Inc()
+		return
+	}
+
+	err = r.do(ctx, "rollback reload", s.sendSignal)
+	if err != nil {
+		s.log().Errorf("%v: %s", ErrConsumerRollback, err)
+		rollbacks.WithLabelValues("failure").Inc()
+		return
+	}
+	s.reloadNeeded = false
+
+	s.log().Warnf("rolled back %s to the last config Prometheus loaded", s.output.PrometheusConfig)
+	rollbacks.WithLabelValues("success").Inc()
+}
+
+func (s *sink) sendSignal() error {
+	start := time.Now()
+	err := s.reloader.signal()
+	reloadDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		reloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	reloads.WithLabelValues("success").Inc()
+
+	s.log().Print("sent reload signal to prometheus")
+	return nil
+}
+
+func (c *consumer) sink(group string) *sink {
+	for _, s := range c.sinks {
+		if s.group == group {
+			return s
+		}
+	}
+	return nil
+}
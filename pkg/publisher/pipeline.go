@@ -0,0 +1,123 @@
+package publisher
+
+import (
+	"sort"
+
+	"github.com/docker/docker/api/types"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+type eventProcessor interface {
+	processEvents([]eventlog.Event) []eventlog.Event
+}
+
+type targetProcessor interface {
+	processTarget(target, types.ContainerJSON) target
+}
+
+type eventProcessorFunc func([]eventlog.Event) []eventlog.Event
+
+func (f eventProcessorFunc) processEvents(events []eventlog.Event) []eventlog.Event {
+	return f(events)
+}
+
+type targetProcessorFunc func(target, types.ContainerJSON) target
+
+func (f targetProcessorFunc) processTarget(t target, inspect types.ContainerJSON) target {
+	return f(t, inspect)
+}
+
+var eventProcessors = map[string]func(cfg Config) eventProcessor{
+	"dedupe": func(cfg Config) eventProcessor {
+		return eventProcessorFunc(dedupeEvents)
+	},
+}
+
+var targetProcessors = map[string]func(cfg Config) targetProcessor{
+	"metadata": func(cfg Config) targetProcessor {
+		return targetProcessorFunc(func(t target, inspect types.ContainerJSON) target {
+			return t.withLabels(metadataLabels(inspect, cfg.TargetLabels))
+		})
+	},
+	"runtime_stats": func(cfg Config) targetProcessor {
+		return targetProcessorFunc(func(t target, inspect types.ContainerJSON) target {
+			recordRuntimeStats(inspect)
+			return t
+		})
+	},
+	"external_labels": func(cfg Config) targetProcessor {
+		return targetProcessorFunc(func(t target, inspect types.ContainerJSON) target {
+			return t.withLabels(cfg.ExternalLabels)
+		})
+	},
+}
+
+var (
+	defaultEventProcessors  = []string{"dedupe"}
+	defaultTargetProcessors = []string{"metadata", "external_labels"}
+)
+
+type pipeline struct {
+	events  []eventProcessor
+	targets []targetProcessor
+}
+
+func newPipeline(cfg Config) pipeline {
+	var p pipeline
+	for _, name := range cfg.Pipeline.Events {
+		p.events = append(p.events, eventProcessors[name](cfg))
+	}
+	for _, name := range cfg.Pipeline.Targets {
+		p.targets = append(p.targets, targetProcessors[name](cfg))
+	}
+	return p
+}
+
+// processEvents hands the processors the events ordered by when they were recorded, not by
+// which producer pushed them first
+func (p pipeline) processEvents(events []eventlog.Event) []eventlog.Event {
+	events = byRecordedAt(events)
+	for _, processor := range p.events {
+		events = processor.processEvents(events)
+	}
+	return events
+}
+
+func (p pipeline) processTarget(t target, inspect types.ContainerJSON) target {
+	for _, processor := range p.targets {
+		t = processor.processTarget(t, inspect)
+	}
+	return t
+}
+
+// dedupeEvents keeps one event per container, the one that moved it into its final state; a
+// restarted container's die and start then become a single re-inspect that replaces its target
+// in place
+func dedupeEvents(events []eventlog.Event) []eventlog.Event {
+	transition := make(map[string]int, len(events))
+	for i, event := range events {
+		if last, ok := transition[event.ContainerID]; ok && events[last].Up == event.Up {
+			continue
+		}
+		transition[event.ContainerID] = i
+	}
+
+	deduped := make([]eventlog.Event, 0, len(transition))
+	for i, event := range events {
+		if transition[event.ContainerID] == i {
+			deduped = append(deduped, event)
+		}
+	}
+	return deduped
+}
+
+// byRecordedAt is a copy of events, oldest first
+func byRecordedAt(events []eventlog.Event) []eventlog.Event {
+	sorted := make([]eventlog.Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].RecordedAt.Before(sorted[j].RecordedAt)
+	})
+	return sorted
+}
@@ -0,0 +1,99 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+func TestDedupeEvents(t *testing.T) {
+	at := time.Now()
+	event := func(id string, action eventlog.Type, up bool, offset int) eventlog.Event {
+		return eventlog.Event{
+			Action:      action,
+			Up:          up,
+			ContainerID: id,
+			Name:        "job-" + id,
+			RecordedAt:  at.Add(time.Duration(offset) * time.Millisecond),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		pushed []eventlog.Event
+		want   []eventlog.Event
+	}{
+		{
+			name: "stop then start keeps the start",
+			pushed: []eventlog.Event{
+				event("a", eventlog.Die, false, 0),
+				event("a", eventlog.Stop, false, 1),
+				event("a", eventlog.Start, true, 2),
+			},
+			want: []eventlog.Event{event("a", eventlog.Start, true, 2)},
+		},
+		{
+			name: "start then die keeps the die",
+			pushed: []eventlog.Event{
+				event("a", eventlog.Start, true, 0),
+				event("a", eventlog.Die, false, 1),
+				event("a", eventlog.Stop, false, 2),
+			},
+			want: []eventlog.Event{event("a", eventlog.Die, false, 1)},
+		},
+		{
+			name: "stop then start pushed in reverse keeps the start",
+			pushed: []eventlog.Event{
+				event("a", eventlog.Start, true, 2),
+				event("a", eventlog.Stop, false, 1),
+				event("a", eventlog.Die, false, 0),
+			},
+			want: []eventlog.Event{event("a", eventlog.Start, true, 2)},
+		},
+		{
+			name: "start then die pushed in reverse keeps the die",
+			pushed: []eventlog.Event{
+				event("a", eventlog.Die, false, 1),
+				event("a", eventlog.Start, true, 0),
+			},
+			want: []eventlog.Event{event("a", eventlog.Die, false, 1)},
+		},
+		{
+			name: "a scan after the start keeps the start",
+			pushed: []eventlog.Event{
+				event("a", eventlog.Start, true, 0),
+				event("a", eventlog.Running, true, 1),
+			},
+			want: []eventlog.Event{event("a", eventlog.Start, true, 0)},
+		},
+		{
+			name: "containers are deduped on their own, oldest first",
+			pushed: []eventlog.Event{
+				event("b", eventlog.Start, true, 1),
+				event("a", eventlog.Start, true, 0),
+				event("a", eventlog.Die, false, 2),
+				event("b", eventlog.Healthy, true, 3),
+			},
+			want: []eventlog.Event{
+				event("b", eventlog.Start, true, 1),
+				event("a", eventlog.Die, false, 2),
+			},
+		},
+	}
+
+	p := newPipeline(DefaultConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.processEvents(tt.pushed)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d: got %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
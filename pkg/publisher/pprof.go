@@ -0,0 +1,22 @@
+package publisher
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newPprofServer(logger *logrus.Logger, addr string) server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return server{
+		logger: logger,
+		srv:    &http.Server{Addr: addr, Handler: mux},
+	}
+}
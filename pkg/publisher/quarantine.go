@@ -0,0 +1,109 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrConsumerQuarantine = fmt.Errorf("consumer quarantining job")
+
+type quarantined struct {
+	target target
+	reason string
+	since  time.Time
+}
+
+func validateTarget(job string, t target) error {
+	if job == "" {
+		return fmt.Errorf("empty job name")
+	}
+	if !utf8.ValidString(job) {
+		return fmt.Errorf("job name is not valid UTF-8")
+	}
+
+	host, port, err := net.SplitHostPort(t.address)
+	if err != nil {
+		return fmt.Errorf("invalid target address %q: %s", t.address, err)
+	}
+	if host == "" {
+		return fmt.Errorf("target address %q has no host", t.address)
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return fmt.Errorf("target address %q has an invalid port", t.address)
+	}
+
+	for name, value := range t.labels {
+		if !labelNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("label %q has a value that is not valid UTF-8", name)
+		}
+	}
+	return nil
+}
+
+// isolate sets aside jobs that would render invalid output so they can't block every other update;
+// a quarantined job keeps its last published version, if there is one. Lookups cut short by ctx
+// say nothing about the targets, so isolate gives up with ctx's error instead of quarantining them
+func (c *consumer) isolate(ctx context.Context, scrapeTargets map[string]target) (map[string]target, error) {
+	isolated := make(map[string]target, len(scrapeTargets))
+	looked := make(map[string]error)
+
+	for job, t := range scrapeTargets {
+		err := validateTarget(job, t)
+		if err == nil && c.resolver != nil {
+			err = resolveTarget(ctx, c.resolver, t, looked)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+		if err == nil {
+			isolated[job] = t
+			c.release(job)
+			continue
+		}
+
+		c.quarantineJob(job, t, err)
+		if previous, ok := c.published[job]; ok && validateTarget(job, previous) == nil {
+			isolated[job] = previous
+		}
+	}
+
+	for job := range c.quarantine {
+		if _, ok := scrapeTargets[job]; !ok {
+			c.release(job)
+		}
+	}
+	return isolated, nil
+}
+
+func (c *consumer) quarantineJob(job string, t target, err error) {
+	since := time.Now()
+	if existing, ok := c.quarantine[job]; ok {
+		since = existing.since
+	} else {
+		c.logger.WithField("job", job).Errorf("%v: %s", ErrConsumerQuarantine, err)
+	}
+
+	c.quarantine[job] = quarantined{
+		target: t,
+		reason: err.Error(),
+		since:  since,
+	}
+	quarantinedJobs.Set(float64(len(c.quarantine)))
+}
+
+func (c *consumer) release(job string) {
+	if _, ok := c.quarantine[job]; !ok {
+		return
+	}
+
+	c.logger.WithField("job", job).Info("released from quarantine")
+	delete(c.quarantine, job)
+	quarantinedJobs.Set(float64(len(c.quarantine)))
+}
@@ -0,0 +1,170 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+
+	"github/rolandvarga/target-explorer/pkg/producer"
+)
+
+var ErrConsumerReconcile = fmt.Errorf("consumer reconciling targets")
+
+type discoveredTarget struct {
+	target      target
+	containerID string
+}
+
+// discover builds the targets of every running container the filters select from scratch,
+// reporting the jobs whose container couldn't be inspected separately
+func (c *consumer) discover(ctx context.Context) (map[string]discoveredTarget, map[string]error, error) {
+	containers, err := c.docker.ContainerList(ctx, types.ContainerListOptions{
+		Filters: c.filter.ListFilters(),
+	})
+	if err != nil {
+		producer.DockerErrors.WithLabelValues("container_list").Inc()
+		return nil, nil, err
+	}
+
+	discovered := make(map[string]discoveredTarget, len(containers))
+	failed := make(map[string]error)
+	for _, container := range containers {
+		if !c.filter.Selects(producer.ContainerSubject(container)) {
+			continue
+		}
+
+		job := c.namer.Name(producer.ContainerIdentity{ID: container.ID, Labels: container.Labels, Name: container.Names[0], Image: container.Image})
+		t, err := c.lookupTargetFor(ctx, container.ID, job)
+		if err == producer.ErrFiltered || err == producer.ErrSuspended {
+			continue
+		}
+		if err != nil {
+			failed[job] = err
+			continue
+		}
+		discovered[job] = discoveredTarget{t, container.ID}
+	}
+	return discovered, failed, nil
+}
+
+// reconcile repairs drift between the published targets and the containers actually running,
+// which events alone can't guarantee after missed events, manual edits or agent downtime
+func (c *consumer) reconcile(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "reconcile")
+	err := c.repair(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func (c *consumer) repair(ctx context.Context) error {
+	discovered, failed, err := c.discover(ctx)
+	if err != nil {
+		err = fmt.Errorf("%v: %s", ErrConsumerReconcile, err)
+		c.logger.Error(err)
+		return err
+	}
+
+	stateMap, err := c.state()
+	if err != nil {
+		err = fmt.Errorf("%v: %s", ErrConsumerReconcile, err)
+		c.logger.Error(err)
+		return err
+	}
+
+	// a job whose container couldn't be inspected keeps its current target
+	var failedErr error
+	for job, err := range failed {
+		c.logger.WithField("job", job).Errorf("%v: %s", ErrConsumerReconcile, err)
+	}
+	if len(failed) > 0 {
+		failedErr = fmt.Errorf("%v: %d containers could not be inspected", ErrConsumerReconcile, len(failed))
+	}
+
+	desired := make(map[string]target, len(discovered))
+	for job, d := range discovered {
+		if c.excluded(job) {
+			continue
+		}
+		desired[job] = d.target
+		c.own(job, d.containerID)
+	}
+
+	changed := 0
+	for job, t := range stateMap {
+		if _, ok := failed[job]; ok {
+			continue
+		}
+		if _, ok := desired[job]; t.managed && !ok {
+			c.logger.WithField("job", job).Info("reconcile: removing target, no running container backs it")
+			c.track(changeRemove, job, t, c.owners[job], reconcileReason)
+			delete(stateMap, job)
+			forgetRuntimeStats(c.owners[job])
+			delete(c.owners, job)
+			changed++
+		}
+	}
+	for job, t := range desired {
+		current, ok := stateMap[job]
+		if ok && current.equal(t) {
+			continue
+		}
+
+		action := changeAdd
+		if ok {
+			action = changeUpdate
+		}
+		c.logger.WithFields(logrus.Fields{"job": job, "container_id": c.owners[job]}).
+			Infof("reconcile: setting target %s", t.address)
+		c.track(action, job, t, c.owners[job], reconcileReason)
+		stateMap[job] = t
+		changed++
+	}
+
+	if changed == 0 {
+		return failedErr
+	}
+
+	c.logger.Infof("reconcile: repaired %d discrepancies", changed)
+	err = c.commit(ctx, stateMap)
+	if err != nil {
+		return err
+	}
+	return failedErr
+}
+
+// once publishes the targets of the running containers a single time and reports whatever
+// the daemon would only have logged or retried later, so scripts can gate on the result
+func (c *consumer) once(ctx context.Context) error {
+	// a one-off run publishes regardless of leader election
+	c.leading = true
+
+	err := c.restore(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	// an unchanged target set isn't committed by reconcile, but the output still has to exist
+	if c.published == nil {
+		stateMap, err := c.state()
+		if err != nil {
+			return fmt.Errorf("%v: %s", ErrConsumerGetCurrentState, err)
+		}
+		err = c.commit(ctx, stateMap)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(c.quarantine) > 0 {
+		return fmt.Errorf("%v: quarantined jobs %s", ErrConsumerReconcile, strings.Join(sortedKeys(c.quarantine), ", "))
+	}
+	return nil
+}
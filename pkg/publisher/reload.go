@@ -0,0 +1,123 @@
+package publisher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	ErrReloaderLoadCA      = fmt.Errorf("reloader loading CA bundle")
+	ErrReloaderLoadCert    = fmt.Errorf("reloader loading client certificate")
+	ErrReloaderReadSecret  = fmt.Errorf("reloader reading secret file")
+	ErrReloaderNewRequest  = fmt.Errorf("reloader creating new request")
+	ErrReloaderMakeRequest = fmt.Errorf("reloader making request")
+)
+
+type reloader struct {
+	cfg    reloadConfig
+	client *http.Client
+}
+
+func newReloader(cfg reloadConfig) (reloader, error) {
+	tlsConf, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return reloader{}, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	return reloader{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout, Transport: transport},
+	}, nil
+}
+
+func newTLSConfig(tc tlsConfig) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		ServerName:         tc.ServerName,
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+	}
+
+	if tc.CAFile != "" {
+		pem, err := os.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrReloaderLoadCA, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: no certificates found in %s", ErrReloaderLoadCA, tc.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	if tc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", ErrReloaderLoadCert, err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConf, nil
+}
+
+func (r reloader) signal() error {
+	req, err := http.NewRequest("POST", r.cfg.Endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrReloaderNewRequest, err)
+	}
+
+	err = r.authorize(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrReloaderMakeRequest, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %s", ErrReloaderMakeRequest, resp.Status)
+	}
+	return nil
+}
+
+// secrets are read on every request so rotated files are picked up without a restart
+func (r reloader) authorize(req *http.Request) error {
+	if ba := r.cfg.BasicAuth; ba.Username != "" {
+		password, err := readSecret(ba.Password, ba.PasswordFile)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(ba.Username, password)
+		return nil
+	}
+
+	token, err := readSecret(r.cfg.BearerToken, r.cfg.BearerTokenFile)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+func readSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", ErrReloaderReadSecret, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
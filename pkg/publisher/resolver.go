@@ -0,0 +1,125 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrResolverLookup = fmt.Errorf("resolver looking up target host")
+
+const (
+	resolverTimeout  = 2 * time.Second
+	resolverCacheTTL = time.Minute
+)
+
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// splitResolver sends lookups for configured domains to their own servers, so target validation
+// agrees with split-horizon setups where Prometheus resolves names differently than the host
+type splitResolver struct {
+	fallback *net.Resolver
+	domains  map[string]*net.Resolver
+}
+
+func newResolver(cfg resolverConfig) resolver {
+	r := splitResolver{
+		fallback: net.DefaultResolver,
+		domains:  make(map[string]*net.Resolver, len(cfg.Domains)),
+	}
+	if len(cfg.Servers) > 0 {
+		r.fallback = dnsResolver(cfg.Servers)
+	}
+	for domain, servers := range cfg.Domains {
+		r.domains[strings.Trim(strings.ToLower(domain), ".")] = dnsResolver(servers)
+	}
+	return &cachedResolver{resolver: r, ttl: resolverCacheTTL, hosts: make(map[string]cachedHost)}
+}
+
+// cachedResolver remembers the hosts that resolved for ttl; failed lookups are always retried
+type cachedResolver struct {
+	resolver
+	ttl time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]cachedHost
+}
+
+type cachedHost struct {
+	addrs []string
+	at    time.Time
+}
+
+func (r *cachedResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	cached, ok := r.hosts[host]
+	r.mu.Unlock()
+	if ok && time.Since(cached.at) < r.ttl {
+		return cached.addrs, nil
+	}
+
+	addrs, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.hosts[host] = cachedHost{addrs, time.Now()}
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+func dnsResolver(servers []string) *net.Resolver {
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			server := servers[int(atomic.AddUint32(&next, 1))%len(servers)]
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+func (r splitResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.forHost(host).LookupHost(ctx, host)
+}
+
+func (r splitResolver) forHost(host string) *net.Resolver {
+	host = strings.Trim(strings.ToLower(host), ".")
+
+	best, bestLen := r.fallback, -1
+	for domain, res := range r.domains {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > bestLen {
+			best, bestLen = res, len(domain)
+		}
+	}
+	return best
+}
+
+// resolveTarget looks t's host up once per cycle: looked holds the outcome for every host the
+// cycle resolved already, since nearly every target shares the same one
+func resolveTarget(ctx context.Context, r resolver, t target, looked map[string]error) error {
+	host, _, err := net.SplitHostPort(t.address)
+	if err != nil || net.ParseIP(host) != nil {
+		return err
+	}
+	if err, ok := looked[host]; ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolverTimeout)
+	defer cancel()
+
+	_, err = r.LookupHost(ctx, host)
+	if err != nil {
+		err = fmt.Errorf("%v: %s", ErrResolverLookup, err)
+	}
+	looked[host] = err
+	return err
+}
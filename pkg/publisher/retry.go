@@ -0,0 +1,43 @@
+package publisher
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type retrier struct {
+	logger *logrus.Logger
+	cfg    retryConfig
+}
+
+func newRetrier(logger *logrus.Logger, cfg retryConfig) retrier {
+	return retrier{logger, cfg}
+}
+
+// do runs fn until it succeeds or the attempts run out; a done ctx ends the wait between
+// attempts, returning the last error
+func (r retrier) do(ctx context.Context, op string, fn func() error) error {
+	backoff := r.cfg.InitialBackoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= r.cfg.Attempts {
+			return err
+		}
+
+		r.logger.Warnf("%s failed (attempt %d/%d), retrying in %s: %s", op, attempt, r.cfg.Attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > r.cfg.MaxBackoff {
+			backoff = r.cfg.MaxBackoff
+		}
+	}
+}
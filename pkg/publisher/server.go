@@ -0,0 +1,49 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrServerListen = fmt.Errorf("server listening")
+
+const serverShutdownTimeout = 5 * time.Second
+
+type server struct {
+	logger *logrus.Logger
+	srv    *http.Server
+}
+
+func newServer(logger *logrus.Logger, addr string, h *health, a *api) server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+	a.register(mux)
+
+	return server{
+		logger: logger,
+		srv:    &http.Server{Addr: addr, Handler: mux},
+	}
+}
+
+func (s server) run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		s.srv.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.Infof("listening on %s", s.srv.Addr)
+	err := s.srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		s.logger.Errorf("%v: %s", ErrServerListen, err)
+	}
+}
@@ -0,0 +1,168 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrStoreOpen = fmt.Errorf("store opening database")
+	ErrStoreSave = fmt.Errorf("store saving targets")
+	ErrStoreLoad = fmt.Errorf("store loading targets")
+)
+
+const restoreReason = "restore"
+
+var targetsBucket = []byte("targets")
+
+type storedTarget struct {
+	ContainerID string            `json:"container_id"`
+	Address     string            `json:"address"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// store persists which container backs each published job, so stop/die events
+// missed while the agent was down can be detected at the next startup
+type store struct {
+	db *bolt.DB
+}
+
+func openStore(path string) (*store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrStoreOpen, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(targetsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%v: %s", ErrStoreOpen, err)
+	}
+	return &store{db}, nil
+}
+
+func (s *store) save(published map[string]target, owners map[string]string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(targetsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(targetsBucket)
+		if err != nil {
+			return err
+		}
+
+		for job, t := range published {
+			if !t.managed {
+				continue
+			}
+
+			v, err := json.Marshal(storedTarget{
+				ContainerID: owners[job],
+				Address:     t.address,
+				Labels:      t.labels,
+			})
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte(job), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%v: %s", ErrStoreSave, err)
+	}
+	return nil
+}
+
+func (s *store) load() (map[string]storedTarget, error) {
+	stored := make(map[string]storedTarget)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(targetsBucket).ForEach(func(k, v []byte) error {
+			var st storedTarget
+			err := json.Unmarshal(v, &st)
+			if err != nil {
+				return err
+			}
+			stored[string(k)] = st
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrStoreLoad, err)
+	}
+	return stored, nil
+}
+
+func (s *store) close() error {
+	return s.db.Close()
+}
+
+// restore picks up ownership from the previous run and drops jobs whose container
+// stopped or disappeared while the agent wasn't watching
+func (c *consumer) restore(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+
+	stored, err := c.store.load()
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
+	stateMap, err := c.state()
+	if err != nil {
+		err = fmt.Errorf("%v: %s", ErrConsumerGetCurrentState, err)
+		c.logger.Error(err)
+		return err
+	}
+
+	changed := false
+	for job, st := range stored {
+		log := c.logger.WithFields(logrus.Fields{"job": job, "container_id": st.ContainerID})
+
+		// the container ID is unknown for jobs that never went through an event
+		if st.ContainerID == "" {
+			continue
+		}
+
+		inspect, err := c.docker.ContainerInspect(ctx, st.ContainerID)
+		running := err == nil && inspect.State != nil && inspect.State.Running
+		if err != nil && !client.IsErrNotFound(err) {
+			log.Errorf("%v: %s", ErrConsumerInspectContainer, err)
+			continue
+		}
+
+		if running {
+			c.owners[job] = st.ContainerID
+			continue
+		}
+
+		if current, ok := stateMap[job]; ok && current.managed {
+			log.Info("removing target, its container stopped while the agent was down")
+			c.track(changeRemove, job, current, st.ContainerID, restoreReason)
+			delete(stateMap, job)
+			changed = true
+		}
+	}
+
+	if changed {
+		return c.commit(ctx, stateMap)
+	}
+	return nil
+}
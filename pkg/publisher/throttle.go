@@ -0,0 +1,146 @@
+package publisher
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+// reloadLimiter allows at most max reloads of one Prometheus in any interval; a reload it
+// refuses stays due and is sent by a later consume cycle
+type reloadLimiter struct {
+	max      int
+	interval time.Duration
+	sent     []time.Time
+}
+
+func newReloadLimiter(cfg throttleConfig) *reloadLimiter {
+	return &reloadLimiter{max: cfg.MaxReloads, interval: cfg.ReloadInterval}
+}
+
+func (l *reloadLimiter) allow(now time.Time) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	recent := l.sent[:0]
+	for _, t := range l.sent {
+		if now.Sub(t) < l.interval {
+			recent = append(recent, t)
+		}
+	}
+	l.sent = recent
+
+	if len(l.sent) >= l.max {
+		return false
+	}
+	l.sent = append(l.sent, now)
+	return true
+}
+
+// flapDetector holds back the events of a container that keeps changing state, such as one
+// crash-looping under a restart policy. Its target stays as it was until the container has
+// been quiet for a whole window, then its last event is applied once. It sees the raw events,
+// ahead of the pipeline, so a cycle's worth of transitions isn't deduped into one
+type flapDetector struct {
+	logger    *logrus.Logger
+	threshold int
+	window    time.Duration
+	seen      map[string][]transition
+	held      map[string]eventlog.Event
+}
+
+// transition is a container going up or down at a point in time
+type transition struct {
+	at time.Time
+	up bool
+}
+
+func newFlapDetector(logger *logrus.Logger, cfg throttleConfig) *flapDetector {
+	return &flapDetector{
+		logger:    logger,
+		threshold: cfg.FlapThreshold,
+		window:    cfg.FlapWindow,
+		seen:      make(map[string][]transition),
+		held:      make(map[string]eventlog.Event),
+	}
+}
+
+// coalesce returns the events to apply now: those of containers that aren't flapping, and the
+// held event of each container that settled
+func (f *flapDetector) coalesce(events []eventlog.Event, now time.Time) []eventlog.Event {
+	if f.threshold <= 0 {
+		return events
+	}
+
+	f.forget(now)
+
+	applied := make([]eventlog.Event, 0, len(events))
+	for _, e := range byRecordedAt(events) {
+		// the scraper and the event stream may both report a start, only changes of state count
+		seen := f.seen[e.ContainerID]
+		if n := len(seen); n == 0 || seen[n-1].up != e.Up {
+			seen = append(seen, transition{e.RecordedAt, e.Up})
+			f.seen[e.ContainerID] = seen
+		}
+
+		if len(seen) < f.threshold {
+			applied = append(applied, e)
+			continue
+		}
+
+		held, holding := f.held[e.ContainerID]
+		if !holding {
+			f.logger.WithFields(eventFields(e)).Warnf("container is flapping, holding its events until it is quiet for %s", f.window)
+		}
+		if !holding || !e.RecordedAt.Before(held.RecordedAt) {
+			f.held[e.ContainerID] = e
+		}
+		eventsCoalesced.Inc()
+	}
+
+	for id, e := range f.held {
+		if len(f.recent(id, now)) > 0 {
+			continue
+		}
+		f.logger.WithFields(eventFields(e)).Info("container settled, applying its last event")
+		applied = append(applied, e)
+		delete(f.held, id)
+	}
+	flappingContainers.Set(float64(len(f.held)))
+	return applied
+}
+
+// due reports whether a held container has settled, so a cycle without events still applies it
+func (f *flapDetector) due(now time.Time) bool {
+	for id := range f.held {
+		if len(f.recent(id, now)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *flapDetector) recent(containerID string, now time.Time) []transition {
+	var recent []transition
+	for _, t := range f.seen[containerID] {
+		if now.Sub(t.at) < f.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(f.seen, containerID)
+	}
+	return recent
+}
+
+// forget drops the transitions that left the window, and with them the containers that went quiet
+func (f *flapDetector) forget(now time.Time) {
+	for id := range f.seen {
+		if recent := f.recent(id, now); len(recent) > 0 {
+			f.seen[id] = recent
+		}
+	}
+}
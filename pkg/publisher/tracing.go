@@ -0,0 +1,96 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github/rolandvarga/target-explorer/pkg/eventlog"
+)
+
+var (
+	ErrTracingSetup    = fmt.Errorf("tracing setting up exporter")
+	ErrTracingShutdown = fmt.Errorf("tracing flushing spans")
+)
+
+const (
+	tracingServiceName     = "target-explorer"
+	tracingShutdownTimeout = 5 * time.Second
+
+	jobAttribute    = attribute.Key("target_explorer.job")
+	actionAttribute = attribute.Key("target_explorer.event.action")
+)
+
+// the global tracer, so embedders that installed a provider of their own get the spans too
+var tracer = otel.Tracer("github/rolandvarga/target-explorer/pkg/publisher")
+
+// newTracerProvider installs an OTLP exporting provider as the global one; the caller
+// shuts it down to flush the spans still batched
+func newTracerProvider(cfg tracingConfig) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	// the connection is established lazily, an unreachable collector only drops spans
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrTracingSetup, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES win over the defaults
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName(tracingServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", ErrTracingSetup, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+func shutdownTracing(tp *sdktrace.TracerProvider) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	return tp.Shutdown(ctx)
+}
+
+// endSpan records err, if any, as the outcome of span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// eventsWaited is how long the oldest of events sat in the event log before this consume
+// picked it up, telling a slow tick apart from a slow inspect or reload
+func eventsWaited(events []eventlog.Event, now time.Time) time.Duration {
+	var oldest time.Time
+	for _, event := range events {
+		if oldest.IsZero() || event.RecordedAt.Before(oldest) {
+			oldest = event.RecordedAt
+		}
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return now.Sub(oldest)
+}
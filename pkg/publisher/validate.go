@@ -0,0 +1,196 @@
+package publisher
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ErrValidatePrometheusConfig = fmt.Errorf("validate checking prometheus config")
+
+// ValidateCommand checks the agent config and the existing Prometheus config
+func ValidateCommand(logger *logrus.Logger, args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags := newAgentFlags(fs)
+	configPath := fs.String("prometheus-config", "", "prometheus config to check, defaults to output.prometheus_config")
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if *configPath != "" {
+		cfg.Output.PrometheusConfig = *configPath
+	}
+
+	var problems []error
+	var paths []string
+	for _, o := range cfg.outputs() {
+		var base *baseConfig
+		if o.output.BaseConfig != "" {
+			problems = append(problems, checkPrometheusConfig(o.output.BaseConfig, nil)...)
+			base, err = loadBaseConfig(o.output.BaseConfig)
+			if err != nil {
+				problems = append(problems, err)
+			}
+		}
+		problems = append(problems, checkPrometheusConfig(o.output.PrometheusConfig, base)...)
+		if o.output.FileSDDir != "" {
+			problems = append(problems, checkFileSD(o.output.FileSDDir)...)
+		}
+		paths = append(paths, o.output.PrometheusConfig)
+	}
+	for _, problem := range problems {
+		logger.Errorf("%v: %s", ErrValidatePrometheusConfig, problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	logger.Infof("agent config and %s are valid", strings.Join(paths, ", "))
+}
+
+// checkPrometheusConfig reports every problem of the written config rather than stopping at the first;
+// a config that doesn't exist yet is fine, the agent creates it. The jobs of base, carried through
+// verbatim, were checked in the base config already
+func checkPrometheusConfig(path string, base *baseConfig) []error {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return []error{err}
+	}
+
+	var promConf prometheusConf
+	err = yaml.Unmarshal(b, &promConf)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %s", path, err)}
+	}
+	promConf.discovered, err = serviceDiscoveryJobs(b)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %s", path, err)}
+	}
+	promConf.base = base
+
+	problems := validatePrometheusConf(promConf)
+	for i, problem := range problems {
+		problems[i] = fmt.Errorf("%s: %s", path, problem)
+	}
+	return problems
+}
+
+// validatePrometheusConf catches what would make Prometheus reject the config on reload
+func validatePrometheusConf(promConf prometheusConf) []error {
+	problems := make([]error, 0)
+
+	if promConf.Global.ScrapeInterval != "" {
+		if _, err := model.ParseDuration(promConf.Global.ScrapeInterval); err != nil {
+			problems = append(problems, fmt.Errorf("global scrape_interval: %s", err))
+		}
+	}
+
+	seen := make(map[string]bool, len(promConf.ScrapeConfigs))
+	for i, sc := range promConf.ScrapeConfigs {
+		if sc.JobName == "" {
+			problems = append(problems, fmt.Errorf("scrape config %d has no job_name", i))
+			continue
+		}
+		if seen[sc.JobName] {
+			problems = append(problems, fmt.Errorf("duplicate job %q", sc.JobName))
+		}
+		seen[sc.JobName] = true
+		if promConf.base != nil && promConf.base.jobs[sc.JobName] {
+			continue
+		}
+
+		if len(sc.StaticConfigs) == 0 && len(sc.FileSDConfigs) == 0 && !promConf.discovered[sc.JobName] {
+			problems = append(problems, fmt.Errorf("job %q has no targets", sc.JobName))
+		}
+		for _, static := range sc.StaticConfigs {
+			if len(static.Targets) == 0 {
+				problems = append(problems, fmt.Errorf("job %q has a static config without targets", sc.JobName))
+			}
+			for _, address := range static.Targets {
+				err := validateTarget(sc.JobName, target{address: address, labels: static.Labels})
+				if err != nil {
+					problems = append(problems, fmt.Errorf("job %q: %s", sc.JobName, err))
+				}
+			}
+		}
+		for _, fileSD := range sc.FileSDConfigs {
+			if len(fileSD.Files) == 0 {
+				problems = append(problems, fmt.Errorf("job %q has a file_sd config without files", sc.JobName))
+			}
+		}
+	}
+	return problems
+}
+
+func serviceDiscoveryJobs(b []byte) (map[string]bool, error) {
+	var doc struct {
+		ScrapeConfigs []map[string]interface{} `yaml:"scrape_configs"`
+	}
+	err := yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	discovered := make(map[string]bool)
+	for _, sc := range doc.ScrapeConfigs {
+		job, _ := sc["job_name"].(string)
+		for key := range sc {
+			if strings.HasSuffix(key, "_sd_configs") && key != "file_sd_configs" {
+				discovered[job] = true
+			}
+		}
+	}
+	return discovered, nil
+}
+
+func checkFileSD(dir string) []error {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileSDExt))
+	if err != nil {
+		return []error{err}
+	}
+
+	problems := make([]error, 0)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+
+		var groups []fileSDGroup
+		err = json.Unmarshal(b, &groups)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %s", file, err))
+			continue
+		}
+		for _, group := range groups {
+			job := group.Labels["job"]
+			if job == "" {
+				job = fileSDJobName
+			}
+			for _, address := range group.Targets {
+				err := validateTarget(job, target{address: address, labels: group.Labels})
+				if err != nil {
+					problems = append(problems, fmt.Errorf("%s: %s", file, err))
+				}
+			}
+		}
+	}
+	return problems
+}